@@ -0,0 +1,187 @@
+package dify
+
+import (
+    "encoding/json"
+    "sort"
+    "strings"
+)
+
+// AgentTimeline assembles the agent_thought and message_file events of a
+// streaming Agent chat into ordered steps.
+// Dify sends an agent_thought event several times while a step progresses;
+// the latest event for a step wins.
+type AgentTimeline struct {
+    thoughts map[string]*AgentThought
+    order    []string
+    files    map[string]MessageFile
+    pending  []MessageFile
+}
+
+// NewAgentTimeline returns an empty timeline.
+func NewAgentTimeline() *AgentTimeline {
+    return &AgentTimeline{
+        thoughts: make(map[string]*AgentThought),
+        files:    make(map[string]MessageFile),
+    }
+}
+
+// BuildAgentTimeline assembles the steps of a complete list of stream chunks.
+func BuildAgentTimeline(chunks []ChunkChatCompletionResponse) []AgentThought {
+    timeline := NewAgentTimeline()
+    for _, chunk := range chunks {
+        timeline.Add(chunk)
+    }
+    return timeline.Steps()
+}
+
+// Add records a stream chunk and reports whether it changed the timeline.
+// Chunks of other event types are ignored.
+func (t *AgentTimeline) Add(chunk ChunkChatCompletionResponse) bool {
+    switch chunk.Event {
+    case "agent_thought":
+        thought, ok := t.thoughts[chunk.ID]
+        if !ok {
+            thought = &AgentThought{ID: chunk.ID}
+            t.thoughts[chunk.ID] = thought
+            t.order = append(t.order, chunk.ID)
+        }
+        files, labels := thought.Files, thought.ToolLabels
+        *thought = NewAgentThought(chunk)
+        thought.Files = files
+        if thought.ToolLabels == nil {
+            thought.ToolLabels = labels
+        }
+        t.attachPending(thought)
+        return true
+    case "message_file":
+        file := MessageFile{
+            ID:             chunk.ID,
+            Type:           chunk.Type,
+            BelongsTo:      chunk.BelongsTo,
+            URL:            chunk.URL,
+            ConversationID: chunk.ConversationID,
+        }
+        t.files[file.ID] = file
+        if thought := t.owner(file.ID); thought != nil {
+            thought.addFile(file)
+        } else {
+            t.pending = append(t.pending, file)
+        }
+        return true
+    default:
+        return false
+    }
+}
+
+// Steps returns the agent thoughts ordered by position.
+// Files not yet claimed by a thought are attached to the latest one.
+func (t *AgentTimeline) Steps() []AgentThought {
+    steps := make([]AgentThought, 0, len(t.order))
+    for _, id := range t.order {
+        steps = append(steps, *t.thoughts[id])
+    }
+    sort.SliceStable(steps, func(i, j int) bool {
+        return steps[i].Position < steps[j].Position
+    })
+    if len(t.pending) > 0 && len(steps) > 0 {
+        last := &steps[len(steps)-1]
+        last.Files = append(append([]MessageFile(nil), last.Files...), t.pending...)
+    }
+    return steps
+}
+
+// owner returns the thought that lists the file, if any.
+func (t *AgentTimeline) owner(fileID string) *AgentThought {
+    for _, id := range t.order {
+        thought := t.thoughts[id]
+        for _, f := range thought.MessageFiles {
+            if f == fileID {
+                return thought
+            }
+        }
+    }
+    return nil
+}
+
+// attachPending moves files that arrived before their thought onto it.
+func (t *AgentTimeline) attachPending(thought *AgentThought) {
+    for _, fileID := range thought.MessageFiles {
+        if file, ok := t.files[fileID]; ok {
+            thought.addFile(file)
+        }
+    }
+    remaining := t.pending[:0]
+    for _, file := range t.pending {
+        if !thought.hasFile(file.ID) {
+            remaining = append(remaining, file)
+        }
+    }
+    t.pending = remaining
+}
+
+// NewAgentThought converts an agent_thought stream chunk into an AgentThought,
+// parsing its tool input.
+func NewAgentThought(chunk ChunkChatCompletionResponse) AgentThought {
+    thought := AgentThought{
+        ID:             chunk.ID,
+        MessageID:      chunk.MessageID,
+        ConversationID: chunk.ConversationID,
+        Position:       chunk.Position,
+        Thought:        chunk.Thought,
+        Observation:    chunk.Observation,
+        Tool:           chunk.Tool,
+        ToolLabels:     chunk.ToolLabels,
+        RawToolInput:   chunk.ToolInput,
+        MessageFiles:   chunk.MessageFiles,
+        CreatedAt:      chunk.CreatedAt,
+    }
+    if chunk.ToolInput != "" {
+        var input map[string]interface{}
+        if err := json.Unmarshal([]byte(chunk.ToolInput), &input); err == nil {
+            thought.ToolInput = input
+        }
+    }
+    return thought
+}
+
+// Tools returns the names of the tools used in this step.
+// Dify joins several tools of one step with ";".
+func (a *AgentThought) Tools() []string {
+    var tools []string
+    for _, tool := range strings.Split(a.Tool, ";") {
+        if tool = strings.TrimSpace(tool); tool != "" {
+            tools = append(tools, tool)
+        }
+    }
+    return tools
+}
+
+// InputFor returns the parsed input of a single tool of this step.
+// For single-tool steps whose input is not keyed by tool name, the whole
+// input is returned.
+func (a *AgentThought) InputFor(tool string) map[string]interface{} {
+    if input, ok := a.ToolInput[tool].(map[string]interface{}); ok {
+        return input
+    }
+    if len(a.Tools()) == 1 && a.Tools()[0] == tool {
+        return a.ToolInput
+    }
+    return nil
+}
+
+// hasFile reports whether the file is already attached.
+func (a *AgentThought) hasFile(fileID string) bool {
+    for _, f := range a.Files {
+        if f.ID == fileID {
+            return true
+        }
+    }
+    return false
+}
+
+// addFile attaches a file once.
+func (a *AgentThought) addFile(file MessageFile) {
+    if !a.hasFile(file.ID) {
+        a.Files = append(a.Files, file)
+    }
+}
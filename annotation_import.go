@@ -0,0 +1,450 @@
+package dify
+
+import (
+    "bufio"
+    "context"
+    "crypto/sha256"
+    "encoding/csv"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "os"
+    "strings"
+    "sync"
+)
+
+// AnnotationFormat is a file format for annotation import and export.
+type AnnotationFormat string
+
+const (
+    AnnotationCSV   AnnotationFormat = "csv"
+    AnnotationJSONL AnnotationFormat = "jsonl"
+)
+
+// AnnotationRecord is a question/answer pair read from or written to a file.
+// ID is optional; when set it pins the record to an existing annotation.
+type AnnotationRecord struct {
+    ID       string `json:"id,omitempty"`
+    Question string `json:"question"`
+    Answer   string `json:"answer"`
+}
+
+// ReadAnnotationRecords reads annotation records in the given format.
+// CSV input must start with a header row naming the question and answer
+// columns; an id column is optional.
+func ReadAnnotationRecords(r io.Reader, format AnnotationFormat) ([]AnnotationRecord, error) {
+    switch format {
+    case AnnotationCSV:
+        return readAnnotationCSV(r)
+    case AnnotationJSONL:
+        return readAnnotationJSONL(r)
+    default:
+        return nil, fmt.Errorf("unsupported annotation format: %s", format)
+    }
+}
+
+// WriteAnnotationRecords writes annotation records in the given format.
+func WriteAnnotationRecords(w io.Writer, format AnnotationFormat, records []AnnotationRecord) error {
+    switch format {
+    case AnnotationCSV:
+        writer := csv.NewWriter(w)
+        if err := writer.Write([]string{"id", "question", "answer"}); err != nil {
+            return err
+        }
+        for _, record := range records {
+            if err := writer.Write([]string{record.ID, record.Question, record.Answer}); err != nil {
+                return err
+            }
+        }
+        writer.Flush()
+        return writer.Error()
+    case AnnotationJSONL:
+        encoder := json.NewEncoder(w)
+        for _, record := range records {
+            if err := encoder.Encode(record); err != nil {
+                return err
+            }
+        }
+        return nil
+    default:
+        return fmt.Errorf("unsupported annotation format: %s", format)
+    }
+}
+
+// ExportAnnotations writes every annotation of the app to w and returns
+// the number of annotations written.
+func (c *Client) ExportAnnotations(ctx context.Context, w io.Writer, format AnnotationFormat) (int, error) {
+    annotations, err := c.listAllAnnotations(ctx)
+    if err != nil {
+        return 0, err
+    }
+
+    records := make([]AnnotationRecord, 0, len(annotations))
+    for _, annotation := range annotations {
+        records = append(records, AnnotationRecord{
+            ID:       annotation.ID,
+            Question: annotation.Question,
+            Answer:   annotation.Answer,
+        })
+    }
+    return len(records), WriteAnnotationRecords(w, format, records)
+}
+
+// AnnotationOp is a single change an import applies.
+type AnnotationOp struct {
+    Action       SyncAction
+    AnnotationID string
+    Record       AnnotationRecord
+}
+
+// key identifies the operation in the progress file. It covers the content
+// so that an edited record is not mistaken for one already applied.
+func (op AnnotationOp) key() string {
+    hash := sha256.Sum256([]byte(op.Record.Question + "\x00" + op.Record.Answer))
+    return fmt.Sprintf("%s:%s:%s", op.Action, op.AnnotationID, hex.EncodeToString(hash[:8]))
+}
+
+// AnnotationImportPlan lists the changes needed to make the app's annotations match the input.
+type AnnotationImportPlan struct {
+    Ops       []AnnotationOp
+    Unchanged int
+}
+
+// AnnotationImportResult summarizes an import run.
+type AnnotationImportResult struct {
+    Created int
+    Updated int
+    Deleted int
+    Skipped int
+}
+
+// AnnotationImporter applies annotation records to an app.
+// Records are matched to existing annotations by ID when present and by
+// question otherwise.
+type AnnotationImporter struct {
+    Client *Client
+
+    // Concurrency bounds the number of requests in flight. It defaults to 4.
+    Concurrency int
+
+    // Delete removes existing annotations that are not in the input.
+    Delete bool
+
+    // ProgressPath, if set, records applied operations so an interrupted
+    // import can be resumed. The file is removed after a complete run.
+    ProgressPath string
+}
+
+// Plan lists existing annotations and diffs them against records.
+func (im *AnnotationImporter) Plan(ctx context.Context, records []AnnotationRecord) (*AnnotationImportPlan, error) {
+    existing, err := im.Client.listAllAnnotations(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    byID := make(map[string]Annotation, len(existing))
+    byQuestion := make(map[string]Annotation, len(existing))
+    for _, annotation := range existing {
+        byID[annotation.ID] = annotation
+        byQuestion[normalizeQuestion(annotation.Question)] = annotation
+    }
+
+    plan := &AnnotationImportPlan{}
+    seenQuestions := make(map[string]bool, len(records))
+    matched := make(map[string]bool, len(records))
+    for _, record := range records {
+        question := normalizeQuestion(record.Question)
+        if question == "" {
+            return nil, errors.New("annotation record has an empty question")
+        }
+        if seenQuestions[question] {
+            return nil, fmt.Errorf("duplicate annotation question: %q", record.Question)
+        }
+        seenQuestions[question] = true
+
+        current, ok := byID[record.ID]
+        if record.ID == "" || !ok {
+            current, ok = byQuestion[question]
+        }
+        if !ok {
+            plan.Ops = append(plan.Ops, AnnotationOp{Action: SyncCreate, Record: record})
+            continue
+        }
+
+        matched[current.ID] = true
+        if current.Question == record.Question && current.Answer == record.Answer {
+            plan.Unchanged++
+            continue
+        }
+        plan.Ops = append(plan.Ops, AnnotationOp{Action: SyncUpdate, AnnotationID: current.ID, Record: record})
+    }
+
+    if im.Delete {
+        for _, annotation := range existing {
+            if matched[annotation.ID] {
+                continue
+            }
+            plan.Ops = append(plan.Ops, AnnotationOp{
+                Action:       SyncDelete,
+                AnnotationID: annotation.ID,
+                Record:       AnnotationRecord{ID: annotation.ID, Question: annotation.Question, Answer: annotation.Answer},
+            })
+        }
+    }
+
+    return plan, nil
+}
+
+// Import diffs records against the app's annotations and applies the changes.
+// Failed operations do not stop the others; their errors are joined into the
+// returned error and they are retried by the next run.
+func (im *AnnotationImporter) Import(ctx context.Context, records []AnnotationRecord) (*AnnotationImportResult, error) {
+    plan, err := im.Plan(ctx, records)
+    if err != nil {
+        return nil, err
+    }
+
+    progress, err := openAnnotationProgress(im.ProgressPath)
+    if err != nil {
+        return nil, err
+    }
+    defer progress.Close()
+
+    concurrency := im.Concurrency
+    if concurrency <= 0 {
+        concurrency = 4
+    }
+
+    var (
+        mu     sync.Mutex
+        wg     sync.WaitGroup
+        errs   []error
+        result = &AnnotationImportResult{}
+        sem    = make(chan struct{}, concurrency)
+    )
+    for _, op := range plan.Ops {
+        if progress.done(op.key()) {
+            result.Skipped++
+            continue
+        }
+
+        select {
+        case sem <- struct{}{}:
+        case <-ctx.Done():
+        }
+        if err := ctx.Err(); err != nil {
+            mu.Lock()
+            errs = append(errs, err)
+            mu.Unlock()
+            break
+        }
+
+        wg.Add(1)
+        go func(op AnnotationOp) {
+            defer wg.Done()
+            defer func() { <-sem }()
+
+            err := im.apply(ctx, op)
+
+            mu.Lock()
+            defer mu.Unlock()
+            if err != nil {
+                errs = append(errs, fmt.Errorf("%s annotation %q: %w", op.Action, op.Record.Question, err))
+                return
+            }
+            if err := progress.record(op.key()); err != nil {
+                errs = append(errs, err)
+            }
+            switch op.Action {
+            case SyncCreate:
+                result.Created++
+            case SyncUpdate:
+                result.Updated++
+            case SyncDelete:
+                result.Deleted++
+            }
+        }(op)
+    }
+    wg.Wait()
+
+    if len(errs) > 0 {
+        return result, errors.Join(errs...)
+    }
+    return result, progress.finish()
+}
+
+// apply performs a single operation.
+func (im *AnnotationImporter) apply(ctx context.Context, op AnnotationOp) error {
+    reqBody := AnnotationRequest{Question: op.Record.Question, Answer: op.Record.Answer}
+    switch op.Action {
+    case SyncCreate:
+        _, err := im.Client.CreateAnnotation(ctx, reqBody)
+        return err
+    case SyncUpdate:
+        _, err := im.Client.UpdateAnnotation(ctx, op.AnnotationID, reqBody)
+        return err
+    case SyncDelete:
+        return im.Client.DeleteAnnotation(ctx, op.AnnotationID)
+    default:
+        return fmt.Errorf("unknown annotation action: %s", op.Action)
+    }
+}
+
+// listAllAnnotations pages through every annotation of the app.
+func (c *Client) listAllAnnotations(ctx context.Context) ([]Annotation, error) {
+    var annotations []Annotation
+    for page := 1; ; page++ {
+        listResp, err := c.ListAnnotations(ctx, page, 100, "")
+        if err != nil {
+            return nil, err
+        }
+        annotations = append(annotations, listResp.Data...)
+        if !listResp.HasMore || len(listResp.Data) == 0 {
+            return annotations, nil
+        }
+    }
+}
+
+// normalizeQuestion returns the key used to match records by question.
+func normalizeQuestion(question string) string {
+    return strings.TrimSpace(question)
+}
+
+// readAnnotationCSV reads records from CSV with a header row.
+func readAnnotationCSV(r io.Reader) ([]AnnotationRecord, error) {
+    reader := csv.NewReader(r)
+    reader.FieldsPerRecord = -1
+
+    header, err := reader.Read()
+    if err == io.EOF {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    columns := map[string]int{"id": -1, "question": -1, "answer": -1}
+    for i, name := range header {
+        name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+        if _, ok := columns[name]; ok {
+            columns[name] = i
+        }
+    }
+    if columns["question"] < 0 || columns["answer"] < 0 {
+        return nil, errors.New("annotation CSV must have question and answer columns")
+    }
+
+    field := func(row []string, name string) string {
+        i := columns[name]
+        if i < 0 || i >= len(row) {
+            return ""
+        }
+        return row[i]
+    }
+
+    var records []AnnotationRecord
+    for {
+        row, err := reader.Read()
+        if err == io.EOF {
+            return records, nil
+        }
+        if err != nil {
+            return nil, err
+        }
+        records = append(records, AnnotationRecord{
+            ID:       field(row, "id"),
+            Question: field(row, "question"),
+            Answer:   field(row, "answer"),
+        })
+    }
+}
+
+// readAnnotationJSONL reads one JSON record per line, ignoring blank lines.
+func readAnnotationJSONL(r io.Reader) ([]AnnotationRecord, error) {
+    var records []AnnotationRecord
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+    line := 0
+    for scanner.Scan() {
+        line++
+        text := strings.TrimSpace(scanner.Text())
+        if text == "" {
+            continue
+        }
+        var record AnnotationRecord
+        if err := json.Unmarshal([]byte(text), &record); err != nil {
+            return nil, fmt.Errorf("line %d: %w", line, err)
+        }
+        records = append(records, record)
+    }
+    return records, scanner.Err()
+}
+
+// annotationProgress is the append-only log of applied operation keys.
+type annotationProgress struct {
+    path    string
+    file    *os.File
+    applied map[string]bool
+}
+
+// openAnnotationProgress loads the progress file at path, if any.
+// An empty path disables progress tracking.
+func openAnnotationProgress(path string) (*annotationProgress, error) {
+    progress := &annotationProgress{path: path, applied: make(map[string]bool)}
+    if path == "" {
+        return progress, nil
+    }
+
+    data, err := os.ReadFile(path)
+    if err != nil && !errors.Is(err, fs.ErrNotExist) {
+        return nil, err
+    }
+    for _, key := range strings.Split(string(data), "\n") {
+        if key != "" {
+            progress.applied[key] = true
+        }
+    }
+
+    progress.file, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+    if err != nil {
+        return nil, err
+    }
+    return progress, nil
+}
+
+// done reports whether the operation was applied by an earlier run.
+func (p *annotationProgress) done(key string) bool {
+    return p.applied[key]
+}
+
+// record appends an applied operation to the progress file.
+func (p *annotationProgress) record(key string) error {
+    if p.file == nil {
+        return nil
+    }
+    _, err := p.file.WriteString(key + "\n")
+    return err
+}
+
+// finish removes the progress file after a complete run.
+func (p *annotationProgress) finish() error {
+    if p.file == nil {
+        return nil
+    }
+    p.file.Close()
+    p.file = nil
+    return os.Remove(p.path)
+}
+
+// Close closes the progress file, keeping it for the next run.
+func (p *annotationProgress) Close() error {
+    if p.file == nil {
+        return nil
+    }
+    err := p.file.Close()
+    p.file = nil
+    return err
+}
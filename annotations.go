@@ -0,0 +1,112 @@
+package dify
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/url"
+    "strconv"
+    "time"
+)
+
+// Annotation reply job statuses.
+const (
+    AnnotationJobWaiting    = "waiting"
+    AnnotationJobProcessing = "processing"
+    AnnotationJobCompleted  = "completed"
+    AnnotationJobError      = "error"
+)
+
+// ListAnnotations lists the annotations of the app.
+// Page starts at 1; an empty keyword lists all annotations.
+func (c *Client) ListAnnotations(ctx context.Context, page, limit int, keyword string) (*AnnotationListResponse, error) {
+    query := url.Values{}
+    if page > 0 {
+        query.Set("page", strconv.Itoa(page))
+    }
+    if limit > 0 {
+        query.Set("limit", strconv.Itoa(limit))
+    }
+    if keyword != "" {
+        query.Set("keyword", keyword)
+    }
+    ep := endpoint{name: "ListAnnotations", method: "GET", path: "/apps/annotations", query: query}
+    return do[noBody, AnnotationListResponse](ctx, c, ep, noBody{})
+}
+
+// CreateAnnotation creates a new annotation.
+func (c *Client) CreateAnnotation(ctx context.Context, reqBody AnnotationRequest) (*Annotation, error) {
+    ep := endpoint{name: "CreateAnnotation", method: "POST", path: "/apps/annotations"}
+    return do[AnnotationRequest, Annotation](ctx, c, ep, reqBody)
+}
+
+// UpdateAnnotation replaces the question and answer of an annotation.
+func (c *Client) UpdateAnnotation(ctx context.Context, annotationID string, reqBody AnnotationRequest) (*Annotation, error) {
+    ep := endpoint{name: "UpdateAnnotation", method: "PUT", path: fmt.Sprintf("/apps/annotations/%s", annotationID)}
+    return do[AnnotationRequest, Annotation](ctx, c, ep, reqBody)
+}
+
+// DeleteAnnotation deletes an annotation.
+func (c *Client) DeleteAnnotation(ctx context.Context, annotationID string) error {
+    ep := endpoint{name: "DeleteAnnotation", method: "DELETE", path: fmt.Sprintf("/apps/annotations/%s", annotationID)}
+    _, err := do[noBody, struct{}](ctx, c, ep, noBody{})
+    return err
+}
+
+// EnableAnnotationReply turns on annotation reply with the given embedding
+// model and score threshold. Dify applies the change asynchronously; use
+// WaitAnnotationReplyJob with the returned job to wait for it.
+func (c *Client) EnableAnnotationReply(ctx context.Context, settings AnnotationReplySettings) (*AnnotationReplyJob, error) {
+    return c.setAnnotationReply(ctx, "enable", settings)
+}
+
+// DisableAnnotationReply turns off annotation reply.
+// Like enabling, it starts an asynchronous job.
+func (c *Client) DisableAnnotationReply(ctx context.Context) (*AnnotationReplyJob, error) {
+    return c.setAnnotationReply(ctx, "disable", AnnotationReplySettings{})
+}
+
+// GetAnnotationReplyJob retrieves the status of an annotation reply job.
+// Action is "enable" or "disable", matching the call that started the job.
+func (c *Client) GetAnnotationReplyJob(ctx context.Context, action, jobID string) (*AnnotationReplyJob, error) {
+    ep := endpoint{name: "GetAnnotationReplyJob", method: "GET", path: fmt.Sprintf("/apps/annotation-reply/%s/status/%s", action, jobID)}
+    return do[noBody, AnnotationReplyJob](ctx, c, ep, noBody{})
+}
+
+// WaitAnnotationReplyJob polls an annotation reply job every interval until it
+// completes, fails or ctx is done. A failed job is reported as an error.
+func (c *Client) WaitAnnotationReplyJob(ctx context.Context, action, jobID string, interval time.Duration) (*AnnotationReplyJob, error) {
+    if interval <= 0 {
+        interval = time.Second
+    }
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        job, err := c.GetAnnotationReplyJob(ctx, action, jobID)
+        if err != nil {
+            return nil, err
+        }
+        switch job.JobStatus {
+        case AnnotationJobCompleted:
+            return job, nil
+        case AnnotationJobError:
+            if job.ErrorMsg == "" {
+                return job, errors.New("annotation reply job failed")
+            }
+            return job, fmt.Errorf("annotation reply job failed: %s", job.ErrorMsg)
+        }
+
+        select {
+        case <-ctx.Done():
+            return job, ctx.Err()
+        case <-ticker.C:
+        }
+    }
+}
+
+// setAnnotationReply starts an enable or disable annotation reply job.
+func (c *Client) setAnnotationReply(ctx context.Context, action string, settings AnnotationReplySettings) (*AnnotationReplyJob, error) {
+    ep := endpoint{name: "SetAnnotationReply", method: "POST", path: fmt.Sprintf("/apps/annotation-reply/%s", action)}
+    return do[AnnotationReplySettings, AnnotationReplyJob](ctx, c, ep, settings)
+}
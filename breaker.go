@@ -0,0 +1,220 @@
+package dify
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+const (
+    // CircuitClosed lets all calls through.
+    CircuitClosed CircuitState = iota
+    // CircuitOpen fails calls fast with ErrCircuitOpen.
+    CircuitOpen
+    // CircuitHalfOpen lets a single probe call through after the cool-down.
+    CircuitHalfOpen
+)
+
+// String returns the name of the state.
+func (s CircuitState) String() string {
+    switch s {
+    case CircuitClosed:
+        return "closed"
+    case CircuitOpen:
+        return "open"
+    case CircuitHalfOpen:
+        return "half-open"
+    }
+    return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// ErrCircuitOpen is matched by the errors of calls rejected by an open
+// CircuitBreaker.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// CircuitOpenError is returned for calls rejected by an open CircuitBreaker.
+// It matches ErrCircuitOpen with errors.Is.
+type CircuitOpenError struct {
+    // RetryAt is when the breaker lets a probe call through.
+    RetryAt time.Time
+}
+
+// Error implements the error interface.
+func (e *CircuitOpenError) Error() string {
+    return fmt.Sprintf("%s until %s", ErrCircuitOpen, e.RetryAt.Format(time.RFC3339))
+}
+
+// Is reports whether target is ErrCircuitOpen.
+func (e *CircuitOpenError) Is(target error) bool {
+    return target == ErrCircuitOpen
+}
+
+// CircuitBreaker stops sending calls to a degraded Dify backend. After
+// Threshold consecutive failures it opens and rejects calls for CoolDown,
+// then half-opens and lets one probe call through: a success closes it, a
+// failure opens it again. A probe still in flight after CoolDown, such as
+// an abandoned stream, is given up and the next call probes instead. Install it with Client.Use(breaker.Middleware()).
+// The zero value is ready to use; a breaker may be shared by several clients.
+type CircuitBreaker struct {
+    // Threshold is the number of consecutive failures that opens the
+    // breaker. Defaults to 5.
+    Threshold int
+    // CoolDown is how long the breaker stays open. Defaults to 30 seconds.
+    CoolDown time.Duration
+    // IsFailure classifies the error of a completed call. Defaults to
+    // IsBackendFailure. Calls canceled by the caller never count.
+    IsFailure func(err error) bool
+    // OnStateChange, if set, is called after each state change.
+    OnStateChange func(from, to CircuitState)
+
+    mu       sync.Mutex
+    state    CircuitState
+    failures int
+    openedAt time.Time
+    // probe identifies the half-open probe in flight, if probing.
+    probe    uint64
+    probing  bool
+    probedAt time.Time
+}
+
+// IsBackendFailure reports whether err indicates a degraded backend:
+// a 5xx response, a timeout, a failed connection, or a model provider
+// error such as provider_not_initialize.
+func IsBackendFailure(err error) bool {
+    if err == nil {
+        return false
+    }
+    if errors.Is(err, ErrServerError) || errors.Is(err, ErrProviderNotInitialized) ||
+        errors.Is(err, ErrQuotaExceeded) || errors.Is(err, context.DeadlineExceeded) {
+        return true
+    }
+    var netErr net.Error
+    if errors.As(err, &netErr) && netErr.Timeout() {
+        return true
+    }
+    return isConnectError(err)
+}
+
+// State returns the current state of the breaker.
+func (b *CircuitBreaker) State() CircuitState {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if b.state == CircuitOpen && time.Since(b.openedAt) >= b.coolDown() {
+        return CircuitHalfOpen
+    }
+    return b.state
+}
+
+// Middleware returns a Middleware that guards calls with the breaker.
+// A call's outcome is recorded when it completes, so errors reported in
+// the middle of a stream count as well.
+func (b *CircuitBreaker) Middleware() Middleware {
+    return func(next RoundTrip) RoundTrip {
+        return func(ctx context.Context, call *Call) (*http.Response, error) {
+            probe, err := b.allow()
+            if err != nil {
+                return nil, err
+            }
+            call.OnComplete(func(_ any, err error) { b.record(probe, err) })
+            return next(ctx, call)
+        }
+    }
+}
+
+// allow admits a call, returning the ID of the half-open probe it is or
+// zero, or returns a *CircuitOpenError.
+func (b *CircuitBreaker) allow() (probe uint64, err error) {
+    b.mu.Lock()
+    from := b.state
+    now := time.Now()
+    if b.state == CircuitOpen {
+        retryAt := b.openedAt.Add(b.coolDown())
+        if now.Before(retryAt) {
+            b.mu.Unlock()
+            return 0, &CircuitOpenError{RetryAt: retryAt}
+        }
+        b.state = CircuitHalfOpen
+    }
+    if b.state == CircuitHalfOpen {
+        if retryAt := b.probedAt.Add(b.coolDown()); b.probing && now.Before(retryAt) {
+            b.mu.Unlock()
+            return 0, &CircuitOpenError{RetryAt: retryAt}
+        }
+        b.probe++
+        b.probing = true
+        b.probedAt = now
+        probe = b.probe
+    }
+    to := b.state
+    b.mu.Unlock()
+
+    b.changed(from, to)
+    return probe, nil
+}
+
+// record updates the breaker with the outcome of an admitted call. The
+// outcome of a probe that was given up counts as that of a regular call.
+func (b *CircuitBreaker) record(probeID uint64, err error) {
+    isFailure := b.IsFailure
+    if isFailure == nil {
+        isFailure = IsBackendFailure
+    }
+
+    b.mu.Lock()
+    from := b.state
+    probe := b.probing && probeID != 0 && probeID == b.probe
+    switch {
+    case errors.Is(err, context.Canceled):
+        // The caller gave up; this says nothing about the backend.
+        if probe {
+            b.probing = false
+        }
+    case isFailure(err):
+        b.failures++
+        if probe || (b.state == CircuitClosed && b.failures >= b.threshold()) {
+            b.state = CircuitOpen
+            b.openedAt = time.Now()
+            b.probing = false
+        }
+    default:
+        b.failures = 0
+        if probe {
+            b.state = CircuitClosed
+            b.probing = false
+        }
+    }
+    to := b.state
+    b.mu.Unlock()
+
+    b.changed(from, to)
+}
+
+// changed calls OnStateChange if the state changed.
+func (b *CircuitBreaker) changed(from, to CircuitState) {
+    if from != to && b.OnStateChange != nil {
+        b.OnStateChange(from, to)
+    }
+}
+
+// threshold returns Threshold or its default.
+func (b *CircuitBreaker) threshold() int {
+    if b.Threshold > 0 {
+        return b.Threshold
+    }
+    return 5
+}
+
+// coolDown returns CoolDown or its default.
+func (b *CircuitBreaker) coolDown() time.Duration {
+    if b.CoolDown > 0 {
+        return b.CoolDown
+    }
+    return 30 * time.Second
+}
@@ -0,0 +1,158 @@
+package dify_test
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "slices"
+    "sync"
+    "testing"
+    "time"
+
+    dify "github.com/barlowliu/dify-go"
+    "github.com/barlowliu/dify-go/difytest"
+)
+
+// transitions records the state changes of a CircuitBreaker.
+type transitions struct {
+    mu      sync.Mutex
+    changes []string
+}
+
+func (tr *transitions) record(from, to dify.CircuitState) {
+    tr.mu.Lock()
+    defer tr.mu.Unlock()
+    tr.changes = append(tr.changes, fmt.Sprintf("%s->%s", from, to))
+}
+
+func (tr *transitions) get() []string {
+    tr.mu.Lock()
+    defer tr.mu.Unlock()
+    return slices.Clone(tr.changes)
+}
+
+func newBreakerClient(srv *difytest.Server, coolDown time.Duration) (*dify.Client, *dify.CircuitBreaker, *transitions) {
+    tr := &transitions{}
+    breaker := &dify.CircuitBreaker{Threshold: 2, CoolDown: coolDown, OnStateChange: tr.record}
+    client := srv.Client()
+    client.Use(breaker.Middleware())
+    return client, breaker, tr
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    client, breaker, tr := newBreakerClient(srv, 50*time.Millisecond)
+    serverError := difytest.Error(http.StatusInternalServerError, "internal_server_error", "boom")
+
+    srv.On(difytest.ChatMessages, serverError, serverError)
+    for i := range 2 {
+        if err := sendChat(client, "alice"); !errors.Is(err, dify.ErrServerError) {
+            t.Fatalf("failing call %d = %v", i+1, err)
+        }
+    }
+    if state := breaker.State(); state != dify.CircuitOpen {
+        t.Fatalf("state after %d failures = %s, want open", 2, state)
+    }
+
+    err := sendChat(client, "alice")
+    var openErr *dify.CircuitOpenError
+    if !errors.Is(err, dify.ErrCircuitOpen) || !errors.As(err, &openErr) || openErr.RetryAt.IsZero() {
+        t.Fatalf("call while open = %v, want a *CircuitOpenError", err)
+    }
+    srv.ExpectRequests(t, difytest.ChatMessages, 2)
+
+    time.Sleep(60 * time.Millisecond)
+    if state := breaker.State(); state != dify.CircuitHalfOpen {
+        t.Fatalf("state after the cool-down = %s, want half-open", state)
+    }
+    srv.On(difytest.ChatMessages, serverError)
+    if err := sendChat(client, "alice"); !errors.Is(err, dify.ErrServerError) {
+        t.Fatalf("failing probe = %v", err)
+    }
+    if state := breaker.State(); state != dify.CircuitOpen {
+        t.Fatalf("state after a failed probe = %s, want open", state)
+    }
+
+    time.Sleep(60 * time.Millisecond)
+    if err := sendChat(client, "alice"); err != nil {
+        t.Fatalf("succeeding probe: %v", err)
+    }
+    if state := breaker.State(); state != dify.CircuitClosed {
+        t.Fatalf("state after a successful probe = %s, want closed", state)
+    }
+
+    want := []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}
+    if got := tr.get(); !slices.Equal(got, want) {
+        t.Errorf("state changes = %v, want %v", got, want)
+    }
+}
+
+func TestCircuitBreakerIgnoresCanceledCalls(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    client, breaker, tr := newBreakerClient(srv, time.Minute)
+    srv.Handle(difytest.ChatMessages, func(difytest.Request) difytest.Response {
+        return difytest.Response{Body: dify.ChatCompletionResponse{Answer: "late"}, Delay: time.Second}
+    })
+
+    for range 3 {
+        ctx, cancel := context.WithCancel(context.Background())
+        time.AfterFunc(10*time.Millisecond, cancel)
+        _, _, err := client.SendChatMessage(ctx, dify.ChatMessageRequest{Query: "hi", ResponseMode: "blocking", User: "alice"})
+        if !errors.Is(err, context.Canceled) {
+            t.Fatalf("canceled call = %v", err)
+        }
+    }
+    if state := breaker.State(); state != dify.CircuitClosed {
+        t.Errorf("state after canceled calls = %s, want closed", state)
+    }
+    if changes := tr.get(); len(changes) != 0 {
+        t.Errorf("state changes = %v, want none", changes)
+    }
+}
+
+func TestCircuitBreakerGivesUpAbandonedProbe(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    client, breaker, _ := newBreakerClient(srv, 50*time.Millisecond)
+    serverError := difytest.Error(http.StatusInternalServerError, "internal_server_error", "boom")
+    srv.On(difytest.ChatMessages, serverError, serverError, difytest.Stream(
+        difytest.Message("Hello"),
+        difytest.Message(" world").After(10*time.Second),
+    ))
+
+    for range 2 {
+        sendChat(client, "alice")
+    }
+    time.Sleep(60 * time.Millisecond)
+
+    // The probe is a stream the caller stops reading.
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    _, events, err := client.SendChatMessage(ctx, dify.ChatMessageRequest{Query: "hi", ResponseMode: "streaming", User: "alice"})
+    if err != nil {
+        t.Fatalf("probe: %v", err)
+    }
+    <-events
+
+    if err := sendChat(client, "alice"); !errors.Is(err, dify.ErrCircuitOpen) {
+        t.Fatalf("call while the probe is in flight = %v, want ErrCircuitOpen", err)
+    }
+    time.Sleep(60 * time.Millisecond)
+    if err := sendChat(client, "alice"); err != nil {
+        t.Fatalf("call after the probe was given up: %v", err)
+    }
+    if state := breaker.State(); state != dify.CircuitClosed {
+        t.Fatalf("state = %s, want closed", state)
+    }
+
+    // The abandoned probe ending late does not reopen the breaker.
+    cancel()
+    for range events {
+    }
+    if state := breaker.State(); state != dify.CircuitClosed {
+        t.Errorf("state after the abandoned probe ended = %s, want closed", state)
+    }
+}
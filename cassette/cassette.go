@@ -0,0 +1,387 @@
+// Package cassette records Dify API traffic to files and replays it, so
+// code built on the dify client can be tested offline and
+// deterministically.
+//
+// Record once against a real Dify instance:
+//
+//    rec := cassette.NewRecorder("testdata/chat.json", http.DefaultTransport)
+//    client := dify.NewClient(baseURL, apiKey, dify.WithTransport(rec))
+//
+// and replay in tests:
+//
+//    rep, err := cassette.NewReplayer("testdata/chat.json")
+//    client := dify.NewClient("http://dify.invalid", "test", dify.WithTransport(rep))
+//
+// The replaying client's base URL may point anywhere, but its path must
+// match the recorded one, since requests are matched by path.
+//
+// Streaming responses are stored as the chunks read from the connection,
+// with the delay before each, so server-sent events can be replayed with
+// their original timing. API keys and cookies are scrubbed before saving.
+package cassette
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+    "unicode/utf8"
+)
+
+// ErrNoInteraction is returned by a Replayer for requests the cassette
+// has no unused interaction for.
+var ErrNoInteraction = errors.New("cassette: no matching interaction")
+
+// scrubbed replaces the values of scrubbed headers.
+const scrubbed = "REDACTED"
+
+// ScrubbedHeaders are the headers whose values are never saved.
+var ScrubbedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// Cassette is the content of a cassette file.
+type Cassette struct {
+    Interactions []*Interaction `json:"interactions"`
+}
+
+// Interaction is a recorded request and its response.
+type Interaction struct {
+    Request  Request  `json:"request"`
+    Response Response `json:"response"`
+}
+
+// Request is a recorded request. URL holds the path and query only, so a
+// cassette can be replayed against any host.
+type Request struct {
+    Method string      `json:"method"`
+    URL    string      `json:"url"`
+    Header http.Header `json:"header,omitempty"`
+    Body   Body        `json:"body,omitempty"`
+}
+
+// Response is a recorded response.
+type Response struct {
+    StatusCode int         `json:"status_code"`
+    Header     http.Header `json:"header,omitempty"`
+    Chunks     []Chunk     `json:"chunks,omitempty"`
+}
+
+// Chunk is a part of a response body as it was read from the connection.
+type Chunk struct {
+    // Delay is the time between the previous chunk, or the response
+    // headers, and this chunk.
+    Delay time.Duration `json:"delay"`
+    Data  Body          `json:"data"`
+}
+
+// Body is request or response content. It is saved as a string when it is
+// valid UTF-8 and as base64 otherwise.
+type Body []byte
+
+// MarshalJSON implements json.Marshaler.
+func (b Body) MarshalJSON() ([]byte, error) {
+    if utf8.Valid(b) {
+        return json.Marshal(string(b))
+    }
+    return json.Marshal(map[string][]byte{"base64": b})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (b *Body) UnmarshalJSON(data []byte) error {
+    var text string
+    if err := json.Unmarshal(data, &text); err == nil {
+        *b = Body(text)
+        return nil
+    }
+    var encoded map[string][]byte
+    if err := json.Unmarshal(data, &encoded); err != nil {
+        return err
+    }
+    *b = encoded["base64"]
+    return nil
+}
+
+// Load reads a cassette file.
+func Load(path string) (*Cassette, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    var c Cassette
+    if err := json.Unmarshal(data, &c); err != nil {
+        return nil, fmt.Errorf("cassette %s: %w", path, err)
+    }
+    return &c, nil
+}
+
+// Save writes a cassette file atomically.
+func (c *Cassette) Save(path string) error {
+    data, err := json.MarshalIndent(c, "", "  ")
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+        return err
+    }
+    tmp := path + ".tmp"
+    if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, path)
+}
+
+// scrubHeader returns a copy of header with ScrubbedHeaders replaced.
+func scrubHeader(header http.Header) http.Header {
+    header = header.Clone()
+    for _, name := range ScrubbedHeaders {
+        if header.Get(name) != "" {
+            header.Set(name, scrubbed)
+        }
+    }
+    return header
+}
+
+// Recorder is an http.RoundTripper that sends requests with Transport and
+// saves each request and response to the cassette at Path once the
+// response body has been read or closed.
+type Recorder struct {
+    Path      string
+    Transport http.RoundTripper
+
+    mu       sync.Mutex
+    cassette Cassette
+}
+
+// NewRecorder returns a Recorder writing a new cassette to path.
+func NewRecorder(path string, transport http.RoundTripper) *Recorder {
+    if transport == nil {
+        transport = http.DefaultTransport
+    }
+    return &Recorder{Path: path, Transport: transport}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+    var reqBody []byte
+    if req.Body != nil {
+        var err error
+        if reqBody, err = io.ReadAll(req.Body); err != nil {
+            return nil, err
+        }
+        req.Body.Close()
+        req = req.Clone(req.Context())
+        req.Body = io.NopCloser(bytes.NewReader(reqBody))
+    }
+
+    resp, err := r.Transport.RoundTrip(req)
+    if err != nil {
+        return nil, err
+    }
+
+    interaction := &Interaction{
+        Request: Request{
+            Method: req.Method,
+            URL:    req.URL.RequestURI(),
+            Header: scrubHeader(req.Header),
+            Body:   reqBody,
+        },
+        Response: Response{
+            StatusCode: resp.StatusCode,
+            Header:     scrubHeader(resp.Header),
+        },
+    }
+    resp.Body = &recordingBody{
+        body:        resp.Body,
+        last:        time.Now(),
+        interaction: interaction,
+        save:        r.save,
+    }
+    return resp, nil
+}
+
+// save appends an interaction to the cassette and writes it.
+func (r *Recorder) save(interaction *Interaction) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+    return r.cassette.Save(r.Path)
+}
+
+// recordingBody records the chunks read from a response body.
+type recordingBody struct {
+    body        io.ReadCloser
+    last        time.Time
+    interaction *Interaction
+    save        func(*Interaction) error
+    once        sync.Once
+    saveErr     error
+}
+
+// Read implements io.Reader.
+func (b *recordingBody) Read(p []byte) (int, error) {
+    n, err := b.body.Read(p)
+    if n > 0 {
+        now := time.Now()
+        b.interaction.Response.Chunks = append(b.interaction.Response.Chunks, Chunk{
+            Delay: now.Sub(b.last),
+            Data:  bytes.Clone(p[:n]),
+        })
+        b.last = now
+    }
+    if err == io.EOF {
+        b.finish()
+        if b.saveErr != nil {
+            return n, b.saveErr
+        }
+    }
+    return n, err
+}
+
+// Close implements io.Closer.
+func (b *recordingBody) Close() error {
+    err := b.body.Close()
+    b.finish()
+    if b.saveErr != nil {
+        return b.saveErr
+    }
+    return err
+}
+
+// finish saves the interaction once.
+func (b *recordingBody) finish() {
+    b.once.Do(func() {
+        b.saveErr = b.save(b.interaction)
+    })
+}
+
+// Replayer is an http.RoundTripper that answers requests from a cassette.
+// Each interaction is used once, in recorded order among those matching
+// the request.
+type Replayer struct {
+    // Realtime replays response chunks with their recorded delays.
+    Realtime bool
+    // Match reports whether a recorded request answers req. Defaults to
+    // DefaultMatch.
+    Match func(req *http.Request, body []byte, recorded Request) bool
+
+    mu   sync.Mutex
+    used []bool
+    c    *Cassette
+}
+
+// NewReplayer returns a Replayer for the cassette at path.
+func NewReplayer(path string) (*Replayer, error) {
+    c, err := Load(path)
+    if err != nil {
+        return nil, err
+    }
+    return &Replayer{c: c, used: make([]bool, len(c.Interactions))}, nil
+}
+
+// DefaultMatch matches requests by method, path and query, and by body
+// when both bodies are JSON. Multipart bodies are not compared since their
+// boundaries differ between runs.
+func DefaultMatch(req *http.Request, body []byte, recorded Request) bool {
+    if req.Method != recorded.Method || req.URL.RequestURI() != recorded.URL {
+        return false
+    }
+    var got, want interface{}
+    if json.Unmarshal(body, &got) != nil || json.Unmarshal(recorded.Body, &want) != nil {
+        return true
+    }
+    gotJSON, _ := json.Marshal(got)
+    wantJSON, _ := json.Marshal(want)
+    return bytes.Equal(gotJSON, wantJSON)
+}
+
+// Remaining returns the number of interactions not replayed yet.
+func (r *Replayer) Remaining() int {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    n := 0
+    for _, used := range r.used {
+        if !used {
+            n++
+        }
+    }
+    return n
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+    var body []byte
+    if req.Body != nil {
+        var err error
+        if body, err = io.ReadAll(req.Body); err != nil {
+            return nil, err
+        }
+        req.Body.Close()
+    }
+
+    match := r.Match
+    if match == nil {
+        match = DefaultMatch
+    }
+
+    r.mu.Lock()
+    var interaction *Interaction
+    for i, candidate := range r.c.Interactions {
+        if !r.used[i] && match(req, body, candidate.Request) {
+            r.used[i] = true
+            interaction = candidate
+            break
+        }
+    }
+    r.mu.Unlock()
+    if interaction == nil {
+        return nil, fmt.Errorf("%w for %s %s", ErrNoInteraction, req.Method, req.URL.RequestURI())
+    }
+
+    recorded := interaction.Response
+    resp := &http.Response{
+        Status:     fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+        StatusCode: recorded.StatusCode,
+        Proto:      "HTTP/1.1",
+        ProtoMajor: 1,
+        ProtoMinor: 1,
+        Header:     recorded.Header.Clone(),
+        Request:    req,
+    }
+    if resp.Header == nil {
+        resp.Header = make(http.Header)
+    }
+
+    if !r.Realtime {
+        var data []byte
+        for _, chunk := range recorded.Chunks {
+            data = append(data, chunk.Data...)
+        }
+        resp.Body = io.NopCloser(bytes.NewReader(data))
+        resp.ContentLength = int64(len(data))
+        return resp, nil
+    }
+
+    reader, writer := io.Pipe()
+    go func() {
+        for _, chunk := range recorded.Chunks {
+            select {
+            case <-time.After(chunk.Delay):
+            case <-req.Context().Done():
+                writer.CloseWithError(req.Context().Err())
+                return
+            }
+            if _, err := writer.Write(chunk.Data); err != nil {
+                return
+            }
+        }
+        writer.Close()
+    }()
+    resp.Body = reader
+    resp.ContentLength = -1
+    return resp, nil
+}
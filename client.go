@@ -1,7 +1,8 @@
-package dify-go
+package dify
 
 import (
     "fmt"
+    "net/http"
     "time"
 
     "github.com/hashicorp/go-retryablehttp"
@@ -12,35 +13,96 @@ type Client struct {
     BaseURL    string
     APIKey     string
     HTTPClient *retryablehttp.Client
+
+    userAgent   string
+    defaultUser string
+    appName     string
+    appMode     AppMode
+    headers     http.Header
+    keys        *KeyPool
+    limits      map[string]*limitScope
+    hedge       *hedger
+    log         *callLogger
+    middlewares []Middleware
 }
 
 // NewClient initializes and returns a new Dify API client.
-func NewClient(baseURL, apiKey string) *Client {
+// Without options it retries failed requests according to DefaultRetryPolicy
+// and uses http.DefaultTransport.
+func NewClient(baseURL, apiKey string, opts ...Option) *Client {
+    cfg := &clientConfig{retry: DefaultRetryPolicy}
+    for _, opt := range opts {
+        opt(cfg)
+    }
+
     client := retryablehttp.NewClient()
-    client.RetryMax = 3
-    client.RetryWaitMin = 500 * time.Millisecond
-    client.RetryWaitMax = 2 * time.Second
+    // retryablehttp logs every attempt to stderr by default. Calls are only
+    // logged through WithLogger.
+    client.Logger = nil
+    client.HTTPClient = cfg.buildHTTPClient()
+    client.ErrorHandler = lastResponseErrorHandler
+    cfg.retry.apply(client)
+    var log *callLogger
+    if cfg.logger != nil {
+        log = &callLogger{logger: cfg.logger, redact: cfg.redact}
+        client.RequestLogHook = log.requestLogHook
+        client.ResponseLogHook = log.responseLogHook
+    }
+
+    var hedge *hedger
+    if cfg.hedge != nil {
+        hedge = newHedger(*cfg.hedge)
+    }
 
     return &Client{
-        BaseURL:    baseURL,
-        APIKey:     apiKey,
-        HTTPClient: client,
+        BaseURL:     baseURL,
+        APIKey:      apiKey,
+        HTTPClient:  client,
+        userAgent:   cfg.userAgent,
+        defaultUser: cfg.defaultUser,
+        appName:     cfg.appName,
+        appMode:     cfg.appMode,
+        headers:     cfg.headers,
+        keys:        cfg.keys,
+        limits:      cfg.limits,
+        hedge:       hedge,
+        log:         log,
     }
 }
 
 // SetTimeout allows setting a custom timeout for the HTTP client.
+// The timeout covers the whole exchange including reading a streaming
+// response; use WithResponseHeaderTimeout to bound only the wait for a reply.
 func (c *Client) SetTimeout(timeout time.Duration) {
     c.HTTPClient.HTTPClient.Timeout = timeout
 }
 
+// lastResponseErrorHandler returns the last response once retries are
+// exhausted, so that its status and body surface as an APIError instead of
+// a generic "giving up" error.
+func lastResponseErrorHandler(resp *http.Response, err error, numTries int) (*http.Response, error) {
+    if resp != nil {
+        return resp, nil
+    }
+    return nil, err
+}
+
 // buildURL constructs the full API endpoint URL.
 func (c *Client) buildURL(endpoint string) string {
     return fmt.Sprintf("%s%s", c.BaseURL, endpoint)
 }
 
 // addHeaders adds the necessary headers to the request.
-func (c *Client) addHeaders(req *retryablehttp.Request) {
-    req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.APIKey))
-    req.Header.Set("Content-Type", "application/json")
+func (c *Client) addHeaders(req *retryablehttp.Request, apiKey, contentType string) {
+    for key, values := range c.headers {
+        req.Header[key] = append([]string(nil), values...)
+    }
+    req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
+    if c.userAgent != "" {
+        req.Header.Set("User-Agent", c.userAgent)
+    }
+    if contentType != "" {
+        req.Header.Set("Content-Type", contentType)
+    }
 }
 
@@ -0,0 +1,308 @@
+package dify_test
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+
+    dify "github.com/barlowliu/dify-go"
+    "github.com/barlowliu/dify-go/difytest"
+)
+
+func TestSendChatMessageBlocking(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+
+    resp, events, err := srv.Client().SendChatMessage(context.Background(), dify.ChatMessageRequest{
+        Query:        "hello there",
+        ResponseMode: "blocking",
+        User:         "alice",
+    })
+    if err != nil {
+        t.Fatalf("SendChatMessage: %v", err)
+    }
+    if events != nil {
+        t.Fatal("blocking call returned a stream")
+    }
+    if resp.Answer != "hello there" || resp.ConversationID == "" || resp.MessageID == "" {
+        t.Errorf("got %+v, want the echoed query with IDs", resp)
+    }
+
+    req := srv.ExpectLast(t, difytest.ChatMessages)
+    if got := req.Header.Get("Authorization"); got != "Bearer "+difytest.DefaultAPIKey {
+        t.Errorf("Authorization = %q", got)
+    }
+    if req.User() != "alice" || req.Streaming() {
+        t.Errorf("got user %q, streaming %v", req.User(), req.Streaming())
+    }
+}
+
+func TestSendChatMessageStreaming(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+
+    _, events, err := srv.Client().SendChatMessage(context.Background(), dify.ChatMessageRequest{
+        Query:        "hello there",
+        ResponseMode: "streaming",
+        User:         "alice",
+    })
+    if err != nil {
+        t.Fatalf("SendChatMessage: %v", err)
+    }
+
+    var answer strings.Builder
+    var last dify.ChunkChatCompletionResponse
+    for chunk := range events {
+        if err := chunk.Err(); err != nil {
+            t.Fatalf("stream error: %v", err)
+        }
+        answer.WriteString(chunk.Answer)
+        last = chunk
+    }
+    if answer.String() != "hello there" {
+        t.Errorf("answer = %q, want %q", answer.String(), "hello there")
+    }
+    if last.Event != "message_end" || last.Metadata == nil || last.Metadata.Usage.TotalTokens == 0 {
+        t.Errorf("last event = %+v, want message_end with usage", last)
+    }
+    if !srv.ExpectLast(t, difytest.ChatMessages).Streaming() {
+        t.Error("request did not ask for a stream")
+    }
+}
+
+func TestSendCompletionMessage(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    client := srv.Client()
+    inputs := map[string]interface{}{"query": "write a haiku"}
+
+    resp, _, err := client.SendCompletionMessage(context.Background(), dify.CompletionMessageRequest{
+        Inputs:       inputs,
+        ResponseMode: "blocking",
+        User:         "alice",
+    })
+    if err != nil {
+        t.Fatalf("blocking SendCompletionMessage: %v", err)
+    }
+    if resp.Answer != "write a haiku" || resp.ID == "" {
+        t.Errorf("got %+v, want the echoed query with an ID", resp)
+    }
+
+    _, events, err := client.SendCompletionMessage(context.Background(), dify.CompletionMessageRequest{
+        Inputs:       inputs,
+        ResponseMode: "streaming",
+        User:         "alice",
+    })
+    if err != nil {
+        t.Fatalf("streaming SendCompletionMessage: %v", err)
+    }
+    var answer strings.Builder
+    for chunk := range events {
+        if err := chunk.Err(); err != nil {
+            t.Fatalf("stream error: %v", err)
+        }
+        answer.WriteString(chunk.Answer)
+    }
+    if answer.String() != "write a haiku" {
+        t.Errorf("streamed answer = %q, want %q", answer.String(), "write a haiku")
+    }
+    srv.ExpectRequests(t, difytest.CompletionMessages, 2)
+}
+
+func TestRunWorkflow(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    client := srv.Client()
+    inputs := map[string]interface{}{"topic": "go"}
+
+    resp, _, err := client.RunWorkflow(context.Background(), dify.WorkflowRunRequest{
+        Inputs:       inputs,
+        ResponseMode: "blocking",
+        User:         "alice",
+    })
+    if err != nil {
+        t.Fatalf("blocking RunWorkflow: %v", err)
+    }
+    if resp.Data.Status != "succeeded" || resp.Data.Outputs == nil || *resp.Data.Outputs != `{"topic":"go"}` {
+        t.Errorf("got %+v, want a succeeded run echoing its inputs", resp.Data)
+    }
+
+    status, err := client.GetWorkflowStatus(resp.WorkflowRunID)
+    if err != nil {
+        t.Fatalf("GetWorkflowStatus: %v", err)
+    }
+    if status.ID != resp.WorkflowRunID || status.Status != "succeeded" {
+        t.Errorf("status = %+v, want run %s succeeded", status, resp.WorkflowRunID)
+    }
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    if _, err := client.GetWorkflowStatusContext(ctx, resp.WorkflowRunID); !errors.Is(err, context.Canceled) {
+        t.Errorf("GetWorkflowStatusContext with a canceled context = %v", err)
+    }
+
+    _, events, err := client.RunWorkflow(context.Background(), dify.WorkflowRunRequest{
+        Inputs:       inputs,
+        ResponseMode: "streaming",
+        User:         "alice",
+    })
+    if err != nil {
+        t.Fatalf("streaming RunWorkflow: %v", err)
+    }
+    var got []string
+    for chunk := range events {
+        if err := chunk.Err(); err != nil {
+            t.Fatalf("stream error: %v", err)
+        }
+        if chunk.WorkflowRunID == "" {
+            t.Errorf("%s event without workflow run ID", chunk.Event)
+        }
+        got = append(got, chunk.Event)
+    }
+    if len(got) == 0 || got[0] != "workflow_started" || got[len(got)-1] != "workflow_finished" {
+        t.Errorf("events = %v, want workflow_started ... workflow_finished", got)
+    }
+}
+
+func TestAPIErrorSentinels(t *testing.T) {
+    tests := []struct {
+        name     string
+        response difytest.Response
+        want     error
+    }{
+        {"unauthorized", difytest.Error(http.StatusUnauthorized, "unauthorized", "Access token is invalid"), dify.ErrInvalidAPIKey},
+        {"rate limited", difytest.Error(http.StatusTooManyRequests, "too_many_requests", "slow down"), dify.ErrRateLimited},
+        {"quota exceeded", difytest.Error(http.StatusBadRequest, "provider_quota_exceeded", "no quota"), dify.ErrQuotaExceeded},
+        {"conversation not found", difytest.Error(http.StatusNotFound, "conversation_not_exists", "Conversation Not Exists."), dify.ErrConversationNotFound},
+        {"generic conversation not found", difytest.Error(http.StatusNotFound, "not_found", "Conversation Not Exists."), dify.ErrConversationNotFound},
+        {"not found by status", difytest.Error(http.StatusNotFound, "", "gone"), dify.ErrNotFound},
+        {"invalid param", difytest.Error(http.StatusBadRequest, "invalid_param", "query is required"), dify.ErrInvalidParam},
+        {"app unavailable", difytest.Error(http.StatusBadRequest, "app_unavailable", "App unavailable"), dify.ErrAppUnavailable},
+        {"wrong app mode", difytest.Error(http.StatusBadRequest, "not_chat_app", "Please check if your app mode matches"), dify.ErrAppModeMismatch},
+        {"server error", difytest.Error(http.StatusInternalServerError, "internal_server_error", "boom"), dify.ErrServerError},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            srv := difytest.NewServer()
+            defer srv.Close()
+            srv.On(difytest.ChatMessages, tt.response)
+
+            _, _, err := srv.Client().SendChatMessage(context.Background(), dify.ChatMessageRequest{
+                Query:        "hi",
+                ResponseMode: "blocking",
+                User:         "alice",
+            })
+            if !errors.Is(err, tt.want) {
+                t.Errorf("err = %v, want %v", err, tt.want)
+            }
+            var apiErr *dify.APIError
+            if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.response.Status {
+                t.Errorf("err = %#v, want an *APIError with status %d", err, tt.response.Status)
+            }
+        })
+    }
+}
+
+func TestStreamErrorEvent(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    srv.On(difytest.ChatMessages, difytest.Stream(
+        difytest.Message("Hello"),
+        difytest.ErrorEvent(http.StatusBadRequest, "completion_request_error", "model failed"),
+    ))
+
+    _, events, err := srv.Client().SendChatMessage(context.Background(), dify.ChatMessageRequest{
+        Query:        "hi",
+        ResponseMode: "streaming",
+        User:         "alice",
+    })
+    if err != nil {
+        t.Fatalf("SendChatMessage: %v", err)
+    }
+    var streamErr error
+    for chunk := range events {
+        if err := chunk.Err(); err != nil {
+            streamErr = err
+        }
+    }
+    if !errors.Is(streamErr, dify.ErrCompletionRequestError) {
+        t.Errorf("stream error = %v, want %v", streamErr, dify.ErrCompletionRequestError)
+    }
+}
+
+func TestStreamDropKeepsReadError(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    srv.Chaos(difytest.ChatMessages, difytest.DropAfter(1))
+
+    _, events, err := srv.Client().SendChatMessage(context.Background(), dify.ChatMessageRequest{
+        Query:        "hello there",
+        ResponseMode: "streaming",
+        User:         "alice",
+    })
+    if err != nil {
+        t.Fatalf("SendChatMessage: %v", err)
+    }
+    var streamErr error
+    for chunk := range events {
+        if err := chunk.Err(); err != nil {
+            streamErr = err
+        }
+    }
+    var apiErr *dify.APIError
+    if streamErr == nil || errors.As(streamErr, &apiErr) {
+        t.Errorf("stream error = %#v, want the read error", streamErr)
+    }
+}
+
+func TestStopTask(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    srv.On(difytest.ChatMessages, difytest.Stream(
+        difytest.Message("Hello"),
+        difytest.Message(" world").After(10*time.Second),
+    ))
+    client := srv.Client()
+
+    _, events, err := client.SendChatMessage(context.Background(), dify.ChatMessageRequest{
+        Query:        "hi",
+        ResponseMode: "streaming",
+        User:         "alice",
+    })
+    if err != nil {
+        t.Fatalf("SendChatMessage: %v", err)
+    }
+    first := <-events
+    if first.TaskID == "" {
+        t.Fatalf("first event %+v has no task ID", first)
+    }
+
+    resp, err := client.StopTask(context.Background(), first.TaskID, "alice")
+    if err != nil {
+        t.Fatalf("StopTask: %v", err)
+    }
+    if resp.Result != "success" {
+        t.Errorf("result = %q, want success", resp.Result)
+    }
+    req := srv.ExpectLast(t, difytest.StopChatMessage)
+    if req.PathValues["task_id"] != first.TaskID || req.User() != "alice" {
+        t.Errorf("stop request for task %q by %q", req.PathValues["task_id"], req.User())
+    }
+
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        for chunk := range events {
+            if chunk.Answer != "" {
+                t.Errorf("got %q after the task was stopped", chunk.Answer)
+            }
+        }
+    }()
+    select {
+    case <-done:
+    case <-time.After(5 * time.Second):
+        t.Fatal("stream did not end after StopTask")
+    }
+}
@@ -0,0 +1,143 @@
+package difytest
+
+import (
+    "net/http"
+    "strconv"
+    "time"
+)
+
+// ChaosOption injects a fault into the responses of a route.
+type ChaosOption func(*chaos)
+
+// chaos holds the faults injected into a route. Negative dropAfter and
+// errorAfter are unset.
+type chaos struct {
+    dropAfter      int
+    malformedEvery int
+    splitSize      int
+    rateLimited    int
+    retryAfter     time.Duration
+    stall          time.Duration
+    errorAfter     int
+    errorEvent     Event
+}
+
+// newChaos returns a chaos without faults.
+func newChaos() *chaos {
+    return &chaos{dropAfter: -1, errorAfter: -1}
+}
+
+// DropAfter aborts the connection after n events of a stream, without
+// ending the response; with n = 0 before the first event. Streams of n
+// events are dropped at their end. It panics if n is negative.
+func DropAfter(n int) ChaosOption {
+    if n < 0 {
+        panic("difytest: negative DropAfter")
+    }
+    return func(c *chaos) {
+        c.dropAfter = n
+    }
+}
+
+// MalformedJSON replaces every nth event of a stream with a line of
+// truncated JSON.
+func MalformedJSON(every int) ChaosOption {
+    return func(c *chaos) {
+        c.malformedEvery = every
+    }
+}
+
+// SplitWrites sends events in pieces of at most size bytes, flushing each
+// piece separately so that events straddle TCP writes.
+func SplitWrites(size int) ChaosOption {
+    return func(c *chaos) {
+        c.splitSize = size
+    }
+}
+
+// RateLimited answers the next n requests with 429 Too Many Requests and a
+// Retry-After header. A negative n rate limits every request.
+func RateLimited(n int, retryAfter time.Duration) ChaosOption {
+    return func(c *chaos) {
+        c.rateLimited = n
+        c.retryAfter = retryAfter
+    }
+}
+
+// Stall waits d between the events of a stream, on top of their scripted
+// delays.
+func Stall(d time.Duration) ChaosOption {
+    return func(c *chaos) {
+        c.stall = d
+    }
+}
+
+// ErrorAfter ends a stream with an "error" event after n events; with n = 0
+// it is the only event. Streams of n events get it at their end. It panics
+// if n is negative.
+func ErrorAfter(n, status int, code, message string) ChaosOption {
+    if n < 0 {
+        panic("difytest: negative ErrorAfter")
+    }
+    return func(c *chaos) {
+        c.errorAfter = n
+        c.errorEvent = ErrorEvent(status, code, message)
+    }
+}
+
+// Chaos injects faults into the responses of a route, replacing those set
+// before. An empty route applies to every route without faults of its
+// own. Calling Chaos without options removes the faults of the route.
+func (s *Server) Chaos(route Route, opts ...ChaosOption) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if len(opts) == 0 {
+        delete(s.chaos, route)
+        return
+    }
+    c := newChaos()
+    for _, opt := range opts {
+        opt(c)
+    }
+    s.chaos[route] = c
+}
+
+// chaosFor returns the faults of a route, or nil. s.mu must be held.
+func (s *Server) chaosFor(route Route) *chaos {
+    if c, ok := s.chaos[route]; ok {
+        return c
+    }
+    return s.chaos[""]
+}
+
+// rateLimit returns the rate limit response to send instead of the next
+// response, if any, and counts it. s.mu must be held.
+func (c *chaos) rateLimit() (Response, bool) {
+    if c.rateLimited == 0 {
+        return Response{}, false
+    }
+    if c.rateLimited > 0 {
+        c.rateLimited--
+    }
+    resp := Error(http.StatusTooManyRequests, "too_many_requests", "Too many requests, please try again later.")
+    if c.retryAfter > 0 {
+        seconds := int((c.retryAfter + time.Second - 1) / time.Second)
+        resp.Header = http.Header{"Retry-After": {strconv.Itoa(seconds)}}
+    }
+    return resp, true
+}
+
+// writeEvent sends an event payload, split into pieces if configured.
+func (c *chaos) writeEvent(w http.ResponseWriter, payload []byte) {
+    line := append(append([]byte("data: "), payload...), '\n', '\n')
+    size := len(line)
+    if c.splitSize > 0 {
+        size = c.splitSize
+    }
+    for len(line) > 0 {
+        n := min(size, len(line))
+        w.Write(line[:n])
+        flush(w)
+        line = line[n:]
+    }
+}
@@ -0,0 +1,442 @@
+package difytest
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "path/filepath"
+    "slices"
+    "strconv"
+    "time"
+
+    dify "github.com/barlowliu/dify-go"
+)
+
+// state is the data the server keeps between requests.
+type state struct {
+    seq           map[string]int
+    tasks         map[string]chan struct{}
+    conversations map[string]*conversation
+    // order lists conversation IDs, least recently updated first.
+    order []string
+    runs  map[string]*dify.WorkflowStatusResponse
+    // documents holds the documents of each dataset by ID.
+    documents map[string]map[string]*dify.Document
+}
+
+// conversation is a chat conversation.
+type conversation struct {
+    ID        string         `json:"id"`
+    Name      string         `json:"name"`
+    Inputs    map[string]any `json:"inputs"`
+    Status    string         `json:"status"`
+    CreatedAt int64          `json:"created_at"`
+    UpdatedAt int64          `json:"updated_at"`
+
+    user     string
+    messages []*message
+}
+
+// message is a message of a conversation.
+type message struct {
+    ID                 string         `json:"id"`
+    ConversationID     string         `json:"conversation_id"`
+    Inputs             map[string]any `json:"inputs"`
+    Query              string         `json:"query"`
+    Answer             string         `json:"answer"`
+    Feedback           *feedback      `json:"feedback"`
+    MessageFiles       []any          `json:"message_files"`
+    RetrieverResources []any          `json:"retriever_resources"`
+    CreatedAt          int64          `json:"created_at"`
+}
+
+// feedback is the rating of a message.
+type feedback struct {
+    Rating string `json:"rating"`
+}
+
+// newState returns an empty state.
+func newState() state {
+    return state{
+        seq:           make(map[string]int),
+        tasks:         make(map[string]chan struct{}),
+        conversations: make(map[string]*conversation),
+        runs:          make(map[string]*dify.WorkflowStatusResponse),
+        documents:     make(map[string]map[string]*dify.Document),
+    }
+}
+
+// nextID returns a new ID with a prefix, numbered per prefix.
+func (st *state) nextID(prefix string) string {
+    st.seq[prefix]++
+    return fmt.Sprintf("%s-%d", prefix, st.seq[prefix])
+}
+
+// call holds the IDs the server assigns to a call.
+type call struct {
+    route          Route
+    taskID         string
+    messageID      string
+    conversationID string
+    workflowRunID  string
+    createdAt      int64
+}
+
+// newCall assigns the IDs of a call.
+func (st *state) newCall(route Route, req Request) *call {
+    c := &call{route: route, createdAt: time.Now().Unix()}
+    switch route {
+    case ChatMessages:
+        c.taskID, c.messageID = st.nextID("task"), st.nextID("msg")
+        c.conversationID, _ = req.JSON()["conversation_id"].(string)
+        if c.conversationID == "" {
+            c.conversationID = st.nextID("conv")
+        }
+    case CompletionMessages:
+        c.taskID, c.messageID = st.nextID("task"), st.nextID("msg")
+    case RunWorkflow:
+        c.taskID, c.workflowRunID = st.nextID("task"), st.nextID("run")
+    }
+    return c
+}
+
+// fill returns a copy of event data with the IDs of the call added where
+// they are missing.
+func (c *call) fill(data map[string]any) map[string]any {
+    data = clone(data)
+    if data["event"] == "ping" {
+        return data
+    }
+    switch c.route {
+    case ChatMessages:
+        setDefault(data, "task_id", c.taskID)
+        setDefault(data, "message_id", c.messageID)
+        setDefault(data, "conversation_id", c.conversationID)
+        setDefault(data, "created_at", c.createdAt)
+    case CompletionMessages:
+        setDefault(data, "task_id", c.taskID)
+        setDefault(data, "message_id", c.messageID)
+        setDefault(data, "created_at", c.createdAt)
+    case RunWorkflow:
+        setDefault(data, "task_id", c.taskID)
+        setDefault(data, "workflow_run_id", c.workflowRunID)
+        if nested, ok := data["data"].(map[string]any); ok {
+            nested = clone(nested)
+            if data["event"] == "workflow_started" || data["event"] == "workflow_finished" {
+                setDefault(nested, "id", c.workflowRunID)
+                setDefault(nested, "workflow_id", "workflow-1")
+            }
+            setDefault(nested, "created_at", c.createdAt)
+            data["data"] = nested
+        }
+    }
+    return data
+}
+
+// clone returns a shallow copy of a map.
+func clone(m map[string]any) map[string]any {
+    copied := make(map[string]any, len(m))
+    for k, v := range m {
+        copied[k] = v
+    }
+    return copied
+}
+
+// setDefault sets a key that is not set yet.
+func setDefault(m map[string]any, key string, value any) {
+    if _, ok := m[key]; !ok {
+        m[key] = value
+    }
+}
+
+// saveMessage adds the message of a chat call to its conversation.
+func (st *state) saveMessage(c *call, req Request, answer string) {
+    body := req.JSON()
+    query, _ := body["query"].(string)
+    inputs, _ := body["inputs"].(map[string]any)
+
+    conv, ok := st.conversations[c.conversationID]
+    if !ok {
+        conv = &conversation{
+            ID:        c.conversationID,
+            Name:      "New conversation",
+            Inputs:    inputs,
+            Status:    "normal",
+            CreatedAt: c.createdAt,
+            user:      req.User(),
+        }
+        st.conversations[conv.ID] = conv
+    }
+    conv.UpdatedAt = time.Now().Unix()
+    conv.messages = append(conv.messages, &message{
+        ID:                 c.messageID,
+        ConversationID:     conv.ID,
+        Inputs:             inputs,
+        Query:              query,
+        Answer:             answer,
+        MessageFiles:       []any{},
+        RetrieverResources: []any{},
+        CreatedAt:          c.createdAt,
+    })
+    st.order = append(slices.DeleteFunc(st.order, func(id string) bool { return id == conv.ID }), conv.ID)
+}
+
+// answer returns the answer to a query.
+func (s *Server) answer(query string) string {
+    if s.Answer != nil {
+        return s.Answer(query)
+    }
+    return query
+}
+
+// defaultResponse returns the unscripted response of a route. s.mu must be
+// held.
+func (s *Server) defaultResponse(c *call, req Request) Response {
+    st := &s.state
+    body := req.JSON()
+
+    switch req.Route {
+    case ChatMessages:
+        query, _ := body["query"].(string)
+        if query == "" {
+            return Error(http.StatusBadRequest, "invalid_param", "query is required")
+        }
+        if id, _ := body["conversation_id"].(string); id != "" && st.conversations[id] == nil {
+            return Error(http.StatusNotFound, "conversation_not_exists", "Conversation Not Exists.")
+        }
+        answer := s.answer(query)
+        usage := usageOf(query, answer)
+        if req.Streaming() {
+            return Stream(ChatEvents(answer, usage)...)
+        }
+        return JSON(http.StatusOK, dify.ChatCompletionResponse{
+            MessageID:      c.messageID,
+            ConversationID: c.conversationID,
+            Mode:           "chat",
+            Answer:         answer,
+            Metadata:       dify.Metadata{Usage: usage, RetrieverResources: []dify.RetrieverResource{}},
+            CreatedAt:      c.createdAt,
+        })
+
+    case CompletionMessages:
+        inputs, _ := body["inputs"].(map[string]any)
+        query := ""
+        if q, ok := inputs["query"]; ok {
+            query = fmt.Sprint(q)
+        }
+        answer := s.answer(query)
+        usage := usageOf(query, answer)
+        if req.Streaming() {
+            return Stream(ChatEvents(answer, usage)...)
+        }
+        return JSON(http.StatusOK, dify.CompletionResponse{
+            ID:        c.messageID,
+            Answer:    answer,
+            Metadata:  dify.Metadata{Usage: usage, RetrieverResources: []dify.RetrieverResource{}},
+            CreatedAt: c.createdAt,
+        })
+
+    case RunWorkflow:
+        outputs, _ := body["inputs"].(map[string]any)
+        if outputs == nil {
+            outputs = map[string]any{}
+        }
+        inputsJSON, _ := json.Marshal(outputs)
+        outputsText := string(inputsJSON)
+        now := time.Now().Unix()
+        st.runs[c.workflowRunID] = &dify.WorkflowStatusResponse{
+            ID:          c.workflowRunID,
+            WorkflowID:  "workflow-1",
+            Status:      "succeeded",
+            Inputs:      string(inputsJSON),
+            Outputs:     &outputsText,
+            TotalSteps:  2,
+            CreatedAt:   strconv.FormatInt(c.createdAt, 10),
+            FinishedAt:  strconv.FormatInt(now, 10),
+            ElapsedTime: 0.02,
+        }
+        if req.Streaming() {
+            return Stream(WorkflowEvents(outputs, 0)...)
+        }
+        return JSON(http.StatusOK, dify.WorkflowCompletionResponse{
+            WorkflowRunID: c.workflowRunID,
+            TaskID:        c.taskID,
+            Data: dify.WorkflowRunData{
+                ID:          c.workflowRunID,
+                WorkflowID:  "workflow-1",
+                Status:      "succeeded",
+                Outputs:     &outputsText,
+                ElapsedTime: 0.02,
+                TotalSteps:  2,
+                CreatedAt:   c.createdAt,
+                FinishedAt:  now,
+            },
+        })
+
+    case WorkflowStatus:
+        run, ok := st.runs[req.PathValues["workflow_run_id"]]
+        if !ok {
+            return Error(http.StatusNotFound, "not_found", "Workflow run not found.")
+        }
+        return JSON(http.StatusOK, run)
+
+    case StopChatMessage, StopCompletionMessage, StopWorkflow:
+        if stop, ok := st.tasks[req.PathValues["task_id"]]; ok {
+            close(stop)
+            delete(st.tasks, req.PathValues["task_id"])
+        }
+        return JSON(http.StatusOK, dify.StopResponse{Result: "success"})
+
+    case UploadFile:
+        if len(req.Files) == 0 {
+            return Error(http.StatusBadRequest, "no_file_uploaded", "Please upload your file.")
+        }
+        if len(req.Files) > 1 {
+            return Error(http.StatusBadRequest, "too_many_files", "Only one file is allowed.")
+        }
+        file := req.Files[0]
+        ext := filepath.Ext(file.Name)
+        if len(ext) > 0 {
+            ext = ext[1:]
+        }
+        return JSON(http.StatusCreated, dify.FileUploadResponse{
+            ID:        st.nextID("file"),
+            Name:      file.Name,
+            Size:      len(file.Data),
+            Extension: ext,
+            MimeType:  file.ContentType,
+            CreatedBy: req.User(),
+            CreatedAt: time.Now().Unix(),
+        })
+
+    case Conversations:
+        var convs []*conversation
+        for i := len(st.order) - 1; i >= 0; i-- {
+            if conv := st.conversations[st.order[i]]; req.User() == "" || conv.user == req.User() {
+                convs = append(convs, conv)
+            }
+        }
+        return page(convs, req, func(conv *conversation) string { return conv.ID })
+
+    case DeleteConversation:
+        id := req.PathValues["conversation_id"]
+        if st.conversations[id] == nil {
+            return Error(http.StatusNotFound, "conversation_not_exists", "Conversation Not Exists.")
+        }
+        delete(st.conversations, id)
+        st.order = slices.DeleteFunc(st.order, func(other string) bool { return other == id })
+        return JSON(http.StatusOK, map[string]string{"result": "success"})
+
+    case RenameConversation:
+        conv := st.conversations[req.PathValues["conversation_id"]]
+        if conv == nil {
+            return Error(http.StatusNotFound, "conversation_not_exists", "Conversation Not Exists.")
+        }
+        name, _ := body["name"].(string)
+        if auto, _ := body["auto_generate"].(bool); auto && len(conv.messages) > 0 {
+            name = conv.messages[0].Query
+        }
+        if name == "" {
+            return Error(http.StatusBadRequest, "invalid_param", "name is required")
+        }
+        conv.Name = name
+        return JSON(http.StatusOK, conv)
+
+    case ConversationVariables:
+        if st.conversations[req.PathValues["conversation_id"]] == nil {
+            return Error(http.StatusNotFound, "conversation_not_exists", "Conversation Not Exists.")
+        }
+        return JSON(http.StatusOK, map[string]any{"limit": 20, "has_more": false, "data": []any{}})
+
+    case Messages:
+        conv := st.conversations[req.Query.Get("conversation_id")]
+        if conv == nil {
+            return Error(http.StatusNotFound, "conversation_not_exists", "Conversation Not Exists.")
+        }
+        return page(conv.messages, req, func(m *message) string { return m.ID })
+
+    case MessageFeedback:
+        for _, conv := range st.conversations {
+            for _, m := range conv.messages {
+                if m.ID != req.PathValues["message_id"] {
+                    continue
+                }
+                m.Feedback = nil
+                if rating, _ := body["rating"].(string); rating != "" {
+                    m.Feedback = &feedback{Rating: rating}
+                }
+                return JSON(http.StatusOK, map[string]string{"result": "success"})
+            }
+        }
+        return Error(http.StatusNotFound, "not_found", "Message Not Exists.")
+
+    case SuggestedQuestions:
+        return JSON(http.StatusOK, map[string]any{"result": "success", "data": []string{}})
+
+    case CreateDocumentByFile, UpdateDocumentByFile:
+        if len(req.Files) != 1 {
+            return Error(http.StatusBadRequest, "no_file_uploaded", "Please upload your file.")
+        }
+        datasetID := req.PathValues["dataset_id"]
+        docs := st.documents[datasetID]
+        var doc *dify.Document
+        if req.Route == UpdateDocumentByFile {
+            if doc = docs[req.PathValues["document_id"]]; doc == nil {
+                return Error(http.StatusNotFound, "not_found", "Document not found.")
+            }
+        } else {
+            if docs == nil {
+                docs = make(map[string]*dify.Document)
+                st.documents[datasetID] = docs
+            }
+            doc = &dify.Document{
+                ID:             st.nextID("doc"),
+                Position:       len(docs) + 1,
+                DataSourceType: "upload_file",
+                CreatedFrom:    "api",
+                CreatedAt:      time.Now().Unix(),
+                Enabled:        true,
+            }
+            docs[doc.ID] = doc
+        }
+        doc.Name = req.Files[0].Name
+        doc.WordCount = len(req.Files[0].Data)
+        doc.IndexingStatus = "completed"
+        doc.DisplayStatus = "available"
+        return JSON(http.StatusOK, dify.DocumentResponse{Document: *doc, Batch: st.nextID("batch")})
+
+    case DeleteDocument:
+        docs := st.documents[req.PathValues["dataset_id"]]
+        if docs[req.PathValues["document_id"]] == nil {
+            return Error(http.StatusNotFound, "not_found", "Document not found.")
+        }
+        delete(docs, req.PathValues["document_id"])
+        return JSON(http.StatusOK, map[string]string{"result": "success"})
+    }
+    return Error(http.StatusNotFound, "not_found", "The requested URL was not found on the server.")
+}
+
+// page returns the page of items after the last_id query parameter, of
+// at most limit items.
+func page[T any](items []T, req Request, id func(T) string) Response {
+    if lastID := req.Query.Get("last_id"); lastID != "" {
+        for i, item := range items {
+            if id(item) == lastID {
+                items = items[i+1:]
+                break
+            }
+        }
+    }
+    limit, err := strconv.Atoi(req.Query.Get("limit"))
+    if err != nil || limit <= 0 {
+        limit = 20
+    }
+    hasMore := len(items) > limit
+    if hasMore {
+        items = items[:limit]
+    }
+    if items == nil {
+        items = []T{}
+    }
+    return JSON(http.StatusOK, map[string]any{"limit": limit, "has_more": hasMore, "data": items})
+}
@@ -0,0 +1,191 @@
+package difytest
+
+import (
+    "bytes"
+    "encoding/json"
+    "io"
+    "net/http"
+    "net/url"
+    "strings"
+    "testing"
+)
+
+// maxMemory bounds the memory used to parse multipart uploads.
+const maxMemory = 32 << 20
+
+// Request is a request received by the server.
+type Request struct {
+    Route  Route
+    Method string
+    Path   string
+    // PathValues holds the wildcards of the route, such as "task_id".
+    PathValues map[string]string
+    Query      url.Values
+    Header     http.Header
+    Body       []byte
+    // Form and Files hold the fields and files of multipart requests.
+    Form  url.Values
+    Files []File
+}
+
+// File is a file uploaded in a multipart request.
+type File struct {
+    Field       string
+    Name        string
+    ContentType string
+    Data        []byte
+}
+
+// newRequest records an HTTP request to a route.
+func newRequest(route Route, r *http.Request) (Request, error) {
+    body, err := io.ReadAll(r.Body)
+    if err != nil {
+        return Request{}, err
+    }
+    req := Request{
+        Route:      route,
+        Method:     r.Method,
+        Path:       r.URL.Path,
+        PathValues: make(map[string]string),
+        Query:      r.URL.Query(),
+        Header:     r.Header.Clone(),
+        Body:       body,
+    }
+    for _, name := range wildcards(route) {
+        req.PathValues[name] = r.PathValue(name)
+    }
+
+    if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+        return req, nil
+    }
+    r.Body = io.NopCloser(bytes.NewReader(body))
+    if err := r.ParseMultipartForm(maxMemory); err != nil {
+        return Request{}, err
+    }
+    defer r.MultipartForm.RemoveAll()
+    req.Form = url.Values(r.MultipartForm.Value)
+    for field, headers := range r.MultipartForm.File {
+        for _, header := range headers {
+            f, err := header.Open()
+            if err != nil {
+                return Request{}, err
+            }
+            data, err := io.ReadAll(f)
+            f.Close()
+            if err != nil {
+                return Request{}, err
+            }
+            req.Files = append(req.Files, File{
+                Field:       field,
+                Name:        header.Filename,
+                ContentType: header.Header.Get("Content-Type"),
+                Data:        data,
+            })
+        }
+    }
+    return req, nil
+}
+
+// wildcards returns the names of the wildcards of a route.
+func wildcards(route Route) []string {
+    var names []string
+    rest := string(route)
+    for {
+        start := strings.IndexByte(rest, '{')
+        if start < 0 {
+            return names
+        }
+        end := strings.IndexByte(rest[start:], '}')
+        names = append(names, rest[start+1:start+end])
+        rest = rest[start+end:]
+    }
+}
+
+// Decode decodes the JSON body into v.
+func (r Request) Decode(v any) error {
+    return json.Unmarshal(r.Body, v)
+}
+
+// JSON returns the JSON object of the body, or nil.
+func (r Request) JSON() map[string]any {
+    var body map[string]any
+    if json.Unmarshal(r.Body, &body) != nil {
+        return nil
+    }
+    return body
+}
+
+// User returns the user the request was made for, from its body, form or
+// query.
+func (r Request) User() string {
+    if user, ok := r.JSON()["user"].(string); ok {
+        return user
+    }
+    if user := r.Form.Get("user"); user != "" {
+        return user
+    }
+    return r.Query.Get("user")
+}
+
+// Streaming reports whether the request asked for a streaming response.
+func (r Request) Streaming() bool {
+    return r.JSON()["response_mode"] == "streaming"
+}
+
+// Requests returns the requests received for a route, or all requests if
+// route is empty, in the order they arrived.
+func (s *Server) Requests(route Route) []Request {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    var requests []Request
+    for _, req := range s.requests {
+        if route == "" || req.Route == route {
+            requests = append(requests, req)
+        }
+    }
+    return requests
+}
+
+// LastRequest returns the last request received for a route.
+func (s *Server) LastRequest(route Route) (Request, bool) {
+    requests := s.Requests(route)
+    if len(requests) == 0 {
+        return Request{}, false
+    }
+    return requests[len(requests)-1], true
+}
+
+// ExpectLast returns the last request received for a route, failing the
+// test now if there is none.
+func (s *Server) ExpectLast(t testing.TB, route Route) Request {
+    t.Helper()
+    req, ok := s.LastRequest(route)
+    if !ok {
+        t.Fatalf("difytest: no request to %s", route)
+    }
+    return req
+}
+
+// ExpectRequests fails the test unless n requests were received for a
+// route, and returns them.
+func (s *Server) ExpectRequests(t testing.TB, route Route, n int) []Request {
+    t.Helper()
+    requests := s.Requests(route)
+    if len(requests) != n {
+        t.Errorf("difytest: got %d requests to %s, want %d", len(requests), route, n)
+    }
+    return requests
+}
+
+// ExpectScriptsUsed fails the test if responses queued with On were not
+// served.
+func (s *Server) ExpectScriptsUsed(t testing.TB) {
+    t.Helper()
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for _, route := range routes {
+        if n := len(s.scripts[route]); n > 0 {
+            t.Errorf("difytest: %d scripted responses to %s not served", n, route)
+        }
+    }
+}
@@ -0,0 +1,211 @@
+package difytest
+
+import (
+    "net/http"
+    "strings"
+    "time"
+
+    dify "github.com/barlowliu/dify-go"
+)
+
+// Response is a scripted response. A response with Events is sent as a
+// server-sent event stream, any other as JSON.
+type Response struct {
+    // Status is the HTTP status. Defaults to 200.
+    Status int
+    Header http.Header
+    // Body is encoded as JSON. A []byte or string is sent as is.
+    Body any
+    // Events are sent in order as server-sent events.
+    Events []Event
+    // Delay is waited before the response headers are sent.
+    Delay time.Duration
+}
+
+// Event is a scripted server-sent event.
+type Event struct {
+    // Data is the event payload. The server fills in the task, message,
+    // conversation and workflow run IDs of the call, and created_at, where
+    // they are missing.
+    Data map[string]any
+    // Delay is waited before the event is sent.
+    Delay time.Duration
+}
+
+// JSON returns a Response sending body as JSON.
+func JSON(status int, body any) Response {
+    return Response{Status: status, Body: body}
+}
+
+// Error returns a Response carrying a Dify API error.
+func Error(status int, code, message string) Response {
+    return Response{Status: status, Body: map[string]any{
+        "status":  status,
+        "code":    code,
+        "message": message,
+    }}
+}
+
+// Stream returns a Response sending events.
+func Stream(events ...Event) Response {
+    return Response{Events: events}
+}
+
+// After returns a copy of the event sent after delay.
+func (e Event) After(delay time.Duration) Event {
+    e.Delay = delay
+    return e
+}
+
+// Message returns a chat "message" event carrying a piece of the answer.
+func Message(answer string) Event {
+    return Event{Data: map[string]any{"event": "message", "answer": answer}}
+}
+
+// MessageEnd returns a chat "message_end" event reporting usage.
+func MessageEnd(usage dify.Usage) Event {
+    return Event{Data: map[string]any{
+        "event":    "message_end",
+        "metadata": dify.Metadata{Usage: usage, RetrieverResources: []dify.RetrieverResource{}},
+    }}
+}
+
+// AgentThought returns an "agent_thought" event of an agent chat app.
+func AgentThought(position int, thought, tool, toolInput, observation string) Event {
+    return Event{Data: map[string]any{
+        "event":       "agent_thought",
+        "position":    position,
+        "thought":     thought,
+        "tool":        tool,
+        "tool_input":  toolInput,
+        "observation": observation,
+    }}
+}
+
+// Ping returns a keep-alive "ping" event.
+func Ping() Event {
+    return Event{Data: map[string]any{"event": "ping"}}
+}
+
+// ErrorEvent returns an "error" event, which Dify sends when a call fails
+// after its stream started.
+func ErrorEvent(status int, code, message string) Event {
+    return Event{Data: map[string]any{
+        "event":   "error",
+        "status":  status,
+        "code":    code,
+        "message": message,
+    }}
+}
+
+// WorkflowStarted returns a "workflow_started" event.
+func WorkflowStarted() Event {
+    return workflowEvent("workflow_started", map[string]any{"sequence_number": 1})
+}
+
+// NodeStarted returns a "node_started" event for the node with the given
+// ID, type and title. Index is the position of the node in the run.
+func NodeStarted(nodeID, nodeType, title string, index int) Event {
+    return workflowEvent("node_started", map[string]any{
+        "id":        "exec-" + nodeID,
+        "node_id":   nodeID,
+        "node_type": nodeType,
+        "title":     title,
+        "index":     index,
+    })
+}
+
+// NodeFinished returns a "node_finished" event for a node started by
+// NodeStarted. Status is "succeeded" or "failed".
+func NodeFinished(nodeID, nodeType, title string, index int, status string, outputs map[string]any) Event {
+    return workflowEvent("node_finished", map[string]any{
+        "id":           "exec-" + nodeID,
+        "node_id":      nodeID,
+        "node_type":    nodeType,
+        "title":        title,
+        "index":        index,
+        "status":       status,
+        "outputs":      outputs,
+        "elapsed_time": 0.01,
+    })
+}
+
+// TextChunk returns a workflow "text_chunk" event.
+func TextChunk(text string) Event {
+    return workflowEvent("text_chunk", map[string]any{"text": text})
+}
+
+// WorkflowFinished returns a "workflow_finished" event. Status is
+// "succeeded", "failed" or "stopped".
+func WorkflowFinished(status string, outputs map[string]any, totalTokens int) Event {
+    return workflowEvent("workflow_finished", map[string]any{
+        "status":       status,
+        "outputs":      outputs,
+        "total_tokens": totalTokens,
+        "total_steps":  2,
+        "elapsed_time": 0.02,
+    })
+}
+
+// workflowEvent returns a workflow event with its data.
+func workflowEvent(name string, data map[string]any) Event {
+    return Event{Data: map[string]any{"event": name, "data": data}}
+}
+
+// ChatEvents returns the events of a chat answer: a "message" event per
+// word and a closing "message_end".
+func ChatEvents(answer string, usage dify.Usage) []Event {
+    var events []Event
+    for _, word := range splitWords(answer) {
+        events = append(events, Message(word))
+    }
+    return append(events, MessageEnd(usage))
+}
+
+// WorkflowEvents returns the events of a successful workflow run with a
+// start and an end node.
+func WorkflowEvents(outputs map[string]any, totalTokens int) []Event {
+    return []Event{
+        WorkflowStarted(),
+        NodeStarted("start", "start", "Start", 1),
+        NodeFinished("start", "start", "Start", 1, "succeeded", nil),
+        NodeStarted("end", "end", "End", 2),
+        NodeFinished("end", "end", "End", 2, "succeeded", outputs),
+        WorkflowFinished("succeeded", outputs, totalTokens),
+    }
+}
+
+// splitWords splits text after each space, keeping the spaces, so that the
+// words join back into text.
+func splitWords(text string) []string {
+    var words []string
+    for text != "" {
+        i := strings.IndexByte(text, ' ')
+        if i < 0 {
+            words = append(words, text)
+            break
+        }
+        words = append(words, text[:i+1])
+        text = text[i+1:]
+    }
+    return words
+}
+
+// usageOf returns a Usage counting one token per word.
+func usageOf(prompt, answer string) dify.Usage {
+    promptTokens := len(strings.Fields(prompt))
+    completionTokens := len(strings.Fields(answer))
+    return dify.Usage{
+        PromptTokens:        promptTokens,
+        PromptUnitPrice:     "0",
+        PromptPriceUnit:     "0.000001",
+        PromptPrice:         "0",
+        CompletionTokens:    completionTokens,
+        CompletionUnitPrice: "0",
+        CompletionPriceUnit: "0.000001",
+        CompletionPrice:     "0",
+        TotalTokens:         promptTokens + completionTokens,
+        TotalPrice:          "0",
+        Currency:            "USD",
+    }
+}
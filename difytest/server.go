@@ -0,0 +1,371 @@
+// Package difytest provides an in-process fake Dify server for testing code
+// built on the dify client.
+//
+// The server implements the chat, completion, workflow, stop, file upload,
+// conversation, message and dataset document endpoints with plausible
+// default behaviour: chat answers echo the query, workflows output their
+// inputs, and conversations, messages and documents are kept in memory. Tests script responses,
+// event streams, delays and errors per route, and inspect the requests the
+// server received:
+//
+//    srv := difytest.NewServer()
+//    defer srv.Close()
+//    srv.On(difytest.ChatMessages, difytest.Stream(
+//        difytest.Message("Hello"),
+//        difytest.ErrorEvent(500, "completion_request_error", "boom").After(100*time.Millisecond),
+//    ))
+//
+//    _, events, err := srv.Client().SendChatMessage(ctx, req)
+//    ...
+//    got := srv.ExpectLast(t, difytest.ChatMessages)
+//
+// Chaos injects faults into a route, such as dropped connections,
+// malformed events, rate limiting and stalls, to test how callers cope.
+package difytest
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "slices"
+    "strings"
+    "sync"
+    "time"
+
+    dify "github.com/barlowliu/dify-go"
+)
+
+// Route identifies an endpoint of the server by method and path pattern.
+type Route string
+
+// Routes of the server.
+const (
+    ChatMessages          Route = "POST /chat-messages"
+    StopChatMessage       Route = "POST /chat-messages/{task_id}/stop"
+    CompletionMessages    Route = "POST /completion-messages"
+    StopCompletionMessage Route = "POST /completion-messages/{task_id}/stop"
+    RunWorkflow           Route = "POST /workflows/run"
+    WorkflowStatus        Route = "GET /workflows/run/{workflow_run_id}"
+    StopWorkflow          Route = "POST /workflows/tasks/{task_id}/stop"
+    UploadFile            Route = "POST /files/upload"
+    Conversations         Route = "GET /conversations"
+    DeleteConversation    Route = "DELETE /conversations/{conversation_id}"
+    RenameConversation    Route = "POST /conversations/{conversation_id}/name"
+    ConversationVariables Route = "GET /conversations/{conversation_id}/variables"
+    Messages              Route = "GET /messages"
+    MessageFeedback       Route = "POST /messages/{message_id}/feedbacks"
+    SuggestedQuestions    Route = "GET /messages/{message_id}/suggested"
+    CreateDocumentByFile  Route = "POST /datasets/{dataset_id}/document/create-by-file"
+    UpdateDocumentByFile  Route = "POST /datasets/{dataset_id}/documents/{document_id}/update-by-file"
+    DeleteDocument        Route = "DELETE /datasets/{dataset_id}/documents/{document_id}"
+)
+
+// routes lists the routes of the server.
+var routes = []Route{
+    ChatMessages, StopChatMessage,
+    CompletionMessages, StopCompletionMessage,
+    RunWorkflow, WorkflowStatus, StopWorkflow,
+    UploadFile,
+    Conversations, DeleteConversation, RenameConversation, ConversationVariables,
+    Messages, MessageFeedback, SuggestedQuestions,
+    CreateDocumentByFile, UpdateDocumentByFile, DeleteDocument,
+}
+
+// DefaultAPIKey is the key of clients returned by Server.Client when
+// Server.APIKey is empty.
+const DefaultAPIKey = "app-difytest"
+
+// Server is a fake Dify server. Its API is served both at the root of URL
+// and under /v1.
+type Server struct {
+    *httptest.Server
+
+    // APIKey is the key requests must carry. Empty accepts any key.
+    APIKey string
+    // Answer returns the answer of unscripted chat and completion calls.
+    // Defaults to echoing the query. Set it before making requests.
+    Answer func(query string) string
+
+    mu       sync.Mutex
+    mux      *http.ServeMux
+    scripts  map[Route][]Response
+    handlers map[Route]func(Request) Response
+    chaos    map[Route]*chaos
+    requests []Request
+    state    state
+}
+
+// NewServer starts a Server. Close it when done.
+func NewServer() *Server {
+    s := &Server{
+        mux:      http.NewServeMux(),
+        scripts:  make(map[Route][]Response),
+        handlers: make(map[Route]func(Request) Response),
+        chaos:    make(map[Route]*chaos),
+        state:    newState(),
+    }
+    for _, route := range routes {
+        s.mux.HandleFunc(string(route), func(w http.ResponseWriter, r *http.Request) {
+            s.serve(route, w, r)
+        })
+    }
+    s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+        writeJSON(w, Error(http.StatusNotFound, "not_found", "The requested URL was not found on the server."))
+    })
+    s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if path, ok := strings.CutPrefix(r.URL.Path, "/v1/"); ok {
+            r.URL.Path = "/" + path
+        }
+        s.mux.ServeHTTP(w, r)
+    }))
+    return s
+}
+
+// Client returns a client of the server. It does not retry, so that each
+// scripted error reaches the caller; pass dify.WithRetryPolicy to change
+// that.
+func (s *Server) Client(opts ...dify.Option) *dify.Client {
+    apiKey := s.APIKey
+    if apiKey == "" {
+        apiKey = DefaultAPIKey
+    }
+    opts = append([]dify.Option{dify.WithRetryPolicy(dify.NoRetry)}, opts...)
+    return dify.NewClient(s.URL, apiKey, opts...)
+}
+
+// On queues responses for a route. Each request to the route consumes the
+// next queued response; once they are used up, the route behaves as
+// before.
+func (s *Server) On(route Route, responses ...Response) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.scripts[route] = append(s.scripts[route], responses...)
+}
+
+// Handle replaces the default behaviour of a route with handler. Queued
+// responses are still served first. A nil handler restores the default.
+func (s *Server) Handle(route Route, handler func(Request) Response) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if handler == nil {
+        delete(s.handlers, route)
+        return
+    }
+    s.handlers[route] = handler
+}
+
+// Reset drops scripted responses, handlers, faults, received requests and
+// all conversations and documents.
+func (s *Server) Reset() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.scripts = make(map[Route][]Response)
+    s.handlers = make(map[Route]func(Request) Response)
+    s.chaos = make(map[Route]*chaos)
+    s.requests = nil
+    s.state = newState()
+}
+
+// serve answers a request to a route.
+func (s *Server) serve(route Route, w http.ResponseWriter, r *http.Request) {
+    req, err := newRequest(route, r)
+    if err != nil {
+        writeJSON(w, Error(http.StatusBadRequest, "invalid_param", err.Error()))
+        return
+    }
+
+    s.mu.Lock()
+    s.requests = append(s.requests, req)
+    if !s.authorized(r) {
+        s.mu.Unlock()
+        writeJSON(w, Error(http.StatusUnauthorized, "unauthorized", "Access token is invalid"))
+        return
+    }
+    faults := *newChaos()
+    if c := s.chaosFor(route); c != nil {
+        if resp, limited := c.rateLimit(); limited {
+            s.mu.Unlock()
+            s.write(w, r, nil, &faults, resp)
+            return
+        }
+        faults = *c
+    }
+    call := s.state.newCall(route, req)
+    var resp Response
+    if queue := s.scripts[route]; len(queue) > 0 {
+        resp = queue[0]
+        s.scripts[route] = queue[1:]
+    } else if handler, ok := s.handlers[route]; ok {
+        s.mu.Unlock()
+        resp = handler(req)
+        s.mu.Lock()
+    } else {
+        resp = s.defaultResponse(call, req)
+    }
+    s.mu.Unlock()
+
+    answer, ok := s.write(w, r, call, &faults, resp)
+    if ok && route == ChatMessages {
+        s.mu.Lock()
+        s.state.saveMessage(call, req, answer)
+        s.mu.Unlock()
+    }
+}
+
+// authorized reports whether a request carries the API key. s.mu must be
+// held.
+func (s *Server) authorized(r *http.Request) bool {
+    key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+    if !ok || key == "" {
+        return false
+    }
+    return s.APIKey == "" || key == s.APIKey
+}
+
+// write sends a response, injecting faults into streams. It returns the
+// answer it carried and whether it succeeded.
+func (s *Server) write(w http.ResponseWriter, r *http.Request, call *call, faults *chaos, resp Response) (string, bool) {
+    if !sleep(r.Context(), resp.Delay) {
+        return "", false
+    }
+    status := resp.Status
+    if status == 0 {
+        status = http.StatusOK
+    }
+    for key, values := range resp.Header {
+        w.Header()[key] = values
+    }
+    if resp.Events == nil {
+        answer := writeJSON(w, resp)
+        return answer, status < 300
+    }
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.WriteHeader(status)
+    flush(w)
+
+    stop := s.startTask(call.taskID)
+    defer s.endTask(call.taskID)
+
+    events := resp.Events
+    if faults.errorAfter >= 0 && faults.errorAfter <= len(events) {
+        events = append(slices.Clip(events[:faults.errorAfter]), faults.errorEvent)
+    }
+
+    var answer strings.Builder
+    for i, event := range events {
+        if i == faults.dropAfter {
+            // Abort the connection without terminating the response.
+            panic(http.ErrAbortHandler)
+        }
+        delay := event.Delay
+        if i > 0 {
+            delay += faults.stall
+        }
+        select {
+        case <-time.After(delay):
+        case <-stop:
+            return answer.String(), true
+        case <-r.Context().Done():
+            return answer.String(), false
+        }
+
+        data := call.fill(event.Data)
+        if text, ok := data["answer"].(string); ok {
+            answer.WriteString(text)
+        }
+        payload, err := json.Marshal(data)
+        if err != nil {
+            panic(fmt.Sprintf("difytest: encoding event: %v", err))
+        }
+        if faults.malformedEvery > 0 && (i+1)%faults.malformedEvery == 0 {
+            payload = payload[:len(payload)/2]
+        }
+        faults.writeEvent(w, payload)
+
+        if i == faults.errorAfter {
+            return answer.String(), false
+        }
+    }
+    if len(events) == faults.dropAfter {
+        panic(http.ErrAbortHandler)
+    }
+    return answer.String(), status < 300
+}
+
+// startTask registers a running stream that stop routes can end.
+func (s *Server) startTask(taskID string) <-chan struct{} {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    stop := make(chan struct{})
+    s.state.tasks[taskID] = stop
+    return stop
+}
+
+// endTask unregisters a running stream.
+func (s *Server) endTask(taskID string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    delete(s.state.tasks, taskID)
+}
+
+// writeJSON sends a non-streaming response and returns the answer field
+// of its body, if any.
+func writeJSON(w http.ResponseWriter, resp Response) string {
+    var body []byte
+    switch b := resp.Body.(type) {
+    case nil:
+    case []byte:
+        body = b
+    case string:
+        body = []byte(b)
+    default:
+        var err error
+        if body, err = json.Marshal(b); err != nil {
+            panic(fmt.Sprintf("difytest: encoding response: %v", err))
+        }
+    }
+
+    if w.Header().Get("Content-Type") == "" {
+        w.Header().Set("Content-Type", "application/json")
+    }
+    status := resp.Status
+    if status == 0 {
+        status = http.StatusOK
+    }
+    w.WriteHeader(status)
+    io.Copy(w, bytes.NewReader(body))
+
+    var answer struct {
+        Answer string `json:"answer"`
+    }
+    json.Unmarshal(body, &answer)
+    return answer.Answer
+}
+
+// flush sends buffered data to the client.
+func flush(w http.ResponseWriter) {
+    if f, ok := w.(http.Flusher); ok {
+        f.Flush()
+    }
+}
+
+// sleep waits for d, or until ctx is done. It reports whether d elapsed.
+func sleep(ctx context.Context, d time.Duration) bool {
+    if d <= 0 {
+        return ctx.Err() == nil
+    }
+    timer := time.NewTimer(d)
+    defer timer.Stop()
+    select {
+    case <-timer.C:
+        return true
+    case <-ctx.Done():
+        return false
+    }
+}
@@ -0,0 +1,346 @@
+package difytest_test
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strings"
+    "testing"
+    "time"
+
+    dify "github.com/barlowliu/dify-go"
+    "github.com/barlowliu/dify-go/difytest"
+)
+
+// fakeT records the failures of the Expect helpers instead of failing the
+// test running them.
+type fakeT struct {
+    testing.TB
+    failures []string
+    fatal    bool
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Errorf(format string, args ...any) {
+    t.failures = append(t.failures, fmt.Sprintf(format, args...))
+}
+
+func (t *fakeT) Fatalf(format string, args ...any) {
+    t.Errorf(format, args...)
+    t.fatal = true
+}
+
+func chat(t *testing.T, client *dify.Client, user, query string) *dify.ChatCompletionResponse {
+    t.Helper()
+    resp, _, err := client.SendChatMessage(context.Background(), dify.ChatMessageRequest{
+        Query:        query,
+        ResponseMode: "blocking",
+        User:         user,
+    })
+    if err != nil {
+        t.Fatalf("SendChatMessage: %v", err)
+    }
+    return resp
+}
+
+func TestOnQueuesResponses(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    srv.On(difytest.ChatMessages,
+        difytest.JSON(http.StatusOK, map[string]any{"answer": "first"}),
+        difytest.JSON(http.StatusOK, map[string]any{"answer": "second"}),
+    )
+    client := srv.Client()
+
+    for _, want := range []string{"first", "second", "echo"} {
+        if got := chat(t, client, "alice", "echo").Answer; got != want {
+            t.Errorf("answer = %q, want %q", got, want)
+        }
+    }
+}
+
+func TestHandle(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    client := srv.Client()
+
+    srv.Handle(difytest.ChatMessages, func(req difytest.Request) difytest.Response {
+        return difytest.JSON(http.StatusOK, map[string]any{"answer": "handled for " + req.User()})
+    })
+    srv.On(difytest.ChatMessages, difytest.JSON(http.StatusOK, map[string]any{"answer": "scripted"}))
+
+    if got := chat(t, client, "alice", "hi").Answer; got != "scripted" {
+        t.Errorf("answer = %q, want the queued response first", got)
+    }
+    if got := chat(t, client, "alice", "hi").Answer; got != "handled for alice" {
+        t.Errorf("answer = %q, want the handler's", got)
+    }
+
+    srv.Handle(difytest.ChatMessages, nil)
+    if got := chat(t, client, "alice", "hi").Answer; got != "hi" {
+        t.Errorf("answer = %q, want the default echo", got)
+    }
+}
+
+func TestExpectLast(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+
+    ft := &fakeT{}
+    srv.ExpectLast(ft, difytest.ChatMessages)
+    if !ft.fatal || len(ft.failures) != 1 {
+        t.Errorf("ExpectLast without requests: failures %q, fatal %v", ft.failures, ft.fatal)
+    }
+
+    client := srv.Client()
+    chat(t, client, "alice", "first")
+    chat(t, client, "bob", "second")
+    ft = &fakeT{}
+    req := srv.ExpectLast(ft, difytest.ChatMessages)
+    if len(ft.failures) != 0 {
+        t.Errorf("ExpectLast failed: %q", ft.failures)
+    }
+    if req.User() != "bob" || req.JSON()["query"] != "second" {
+        t.Errorf("last request by %q: %s", req.User(), req.Body)
+    }
+}
+
+func TestExpectScriptsUsed(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    srv.On(difytest.ChatMessages,
+        difytest.JSON(http.StatusOK, map[string]any{"answer": "one"}),
+        difytest.JSON(http.StatusOK, map[string]any{"answer": "two"}),
+    )
+
+    chat(t, srv.Client(), "alice", "hi")
+    ft := &fakeT{}
+    srv.ExpectScriptsUsed(ft)
+    if len(ft.failures) != 1 || ft.fatal {
+        t.Errorf("with a response left: failures %q, fatal %v", ft.failures, ft.fatal)
+    }
+
+    chat(t, srv.Client(), "alice", "hi")
+    ft = &fakeT{}
+    srv.ExpectScriptsUsed(ft)
+    if len(ft.failures) != 0 {
+        t.Errorf("with all responses served: failures %q", ft.failures)
+    }
+}
+
+func TestConversationPaging(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    client := srv.Client()
+
+    var want []string
+    for i := range 5 {
+        want = append([]string{chat(t, client, "alice", fmt.Sprint("question ", i)).ConversationID}, want...)
+    }
+    chat(t, client, "bob", "not alice's")
+
+    var got []string
+    lastID := ""
+    for pages := 0; ; pages++ {
+        if pages == 3 {
+            t.Fatalf("more than 3 pages of 2 for 5 conversations: %v", got)
+        }
+        query := url.Values{"user": {"alice"}, "limit": {"2"}}
+        if lastID != "" {
+            query.Set("last_id", lastID)
+        }
+        var page struct {
+            Limit   int  `json:"limit"`
+            HasMore bool `json:"has_more"`
+            Data    []struct {
+                ID string `json:"id"`
+            } `json:"data"`
+        }
+        getJSON(t, srv, "/v1/conversations?"+query.Encode(), &page)
+        if page.Limit != 2 || len(page.Data) > 2 {
+            t.Fatalf("page of %d conversations with limit %d", len(page.Data), page.Limit)
+        }
+        for _, conv := range page.Data {
+            got = append(got, conv.ID)
+            lastID = conv.ID
+        }
+        if !page.HasMore {
+            break
+        }
+    }
+    if fmt.Sprint(got) != fmt.Sprint(want) {
+        t.Errorf("conversations = %v, want newest first %v", got, want)
+    }
+}
+
+// getJSON sends an authorized GET request to the server and decodes the
+// response.
+func getJSON(t *testing.T, srv *difytest.Server, path string, v any) {
+    t.Helper()
+    req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    req.Header.Set("Authorization", "Bearer "+difytest.DefaultAPIKey)
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        t.Fatalf("GET %s: %s", path, resp.Status)
+    }
+    if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+        t.Fatalf("GET %s: %v", path, err)
+    }
+}
+
+// streamChat sends a streaming chat request to the server and returns the
+// payloads of the events it received, and the error that ended the body
+// early, if any.
+func streamChat(t *testing.T, srv *difytest.Server) (*http.Response, []string, error) {
+    t.Helper()
+    body := strings.NewReader(`{"query":"hi","response_mode":"streaming","user":"alice"}`)
+    req, err := http.NewRequest(http.MethodPost, srv.URL+"/v1/chat-messages", body)
+    if err != nil {
+        t.Fatal(err)
+    }
+    req.Header.Set("Authorization", "Bearer "+difytest.DefaultAPIKey)
+    req.Header.Set("Content-Type", "application/json")
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+
+    var events []string
+    scanner := bufio.NewScanner(resp.Body)
+    for scanner.Scan() {
+        if payload, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+            events = append(events, payload)
+        }
+    }
+    return resp, events, scanner.Err()
+}
+
+// threeMessages is a stream of three message events.
+var threeMessages = difytest.Stream(difytest.Message("a"), difytest.Message("b"), difytest.Message("c"))
+
+func TestChaosErrorAfter(t *testing.T) {
+    for _, n := range []int{0, 1, 3} {
+        t.Run(fmt.Sprint(n), func(t *testing.T) {
+            srv := difytest.NewServer()
+            defer srv.Close()
+            srv.On(difytest.ChatMessages, threeMessages)
+            srv.Chaos(difytest.ChatMessages, difytest.ErrorAfter(n, 500, "completion_request_error", "boom"))
+
+            _, events, err := streamChat(t, srv)
+            if err != nil || len(events) != n+1 {
+                t.Fatalf("got %d events and %v, want %d messages and the error", len(events), err, n)
+            }
+            for i, event := range events {
+                if isError := strings.Contains(event, `"event":"error"`); isError != (i == n) {
+                    t.Errorf("event %d = %s", i, event)
+                }
+            }
+        })
+    }
+}
+
+func TestChaosDropAfter(t *testing.T) {
+    for _, n := range []int{0, 1, 3} {
+        t.Run(fmt.Sprint(n), func(t *testing.T) {
+            srv := difytest.NewServer()
+            defer srv.Close()
+            srv.On(difytest.ChatMessages, threeMessages)
+            srv.Chaos(difytest.ChatMessages, difytest.DropAfter(n))
+
+            _, events, err := streamChat(t, srv)
+            if !errors.Is(err, io.ErrUnexpectedEOF) || len(events) != n {
+                t.Errorf("got %d events and %v, want %d events and a dropped connection", len(events), err, n)
+            }
+        })
+    }
+}
+
+func TestChaosMalformedJSON(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    srv.On(difytest.ChatMessages, threeMessages)
+    srv.Chaos(difytest.ChatMessages, difytest.MalformedJSON(2))
+
+    _, events, err := streamChat(t, srv)
+    if err != nil || len(events) != 3 {
+        t.Fatalf("got %d events and %v", len(events), err)
+    }
+    for i, event := range events {
+        if valid := json.Valid([]byte(event)); valid != (i != 1) {
+            t.Errorf("event %d = %s", i, event)
+        }
+    }
+}
+
+func TestChaosSplitWrites(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    srv.Chaos(difytest.ChatMessages, difytest.SplitWrites(3))
+
+    _, events, err := srv.Client().SendChatMessage(context.Background(), dify.ChatMessageRequest{
+        Query:        "split into pieces",
+        ResponseMode: "streaming",
+        User:         "alice",
+    })
+    if err != nil {
+        t.Fatalf("SendChatMessage: %v", err)
+    }
+    var answer strings.Builder
+    for chunk := range events {
+        if err := chunk.Err(); err != nil {
+            t.Fatalf("chunk: %v", err)
+        }
+        answer.WriteString(chunk.Answer)
+    }
+    if answer.String() != "split into pieces" {
+        t.Errorf("answer = %q", answer.String())
+    }
+}
+
+func TestChaosRateLimited(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    srv.Chaos(difytest.ChatMessages, difytest.RateLimited(2, 1500*time.Millisecond))
+
+    for i := range 2 {
+        resp, _, _ := streamChat(t, srv)
+        if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "2" {
+            t.Errorf("request %d: %s with Retry-After %q, want 429 with 2", i+1, resp.Status, resp.Header.Get("Retry-After"))
+        }
+    }
+    if resp, events, err := streamChat(t, srv); resp.StatusCode != http.StatusOK || err != nil || len(events) == 0 {
+        t.Errorf("request after the rate limit: %s, %d events, %v", resp.Status, len(events), err)
+    }
+    srv.ExpectRequests(t, difytest.ChatMessages, 3)
+}
+
+func TestChaosStall(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    srv.On(difytest.ChatMessages, threeMessages)
+    srv.Chaos(difytest.ChatMessages, difytest.Stall(30*time.Millisecond))
+
+    start := time.Now()
+    _, events, err := streamChat(t, srv)
+    if err != nil || len(events) != 3 {
+        t.Fatalf("got %d events and %v", len(events), err)
+    }
+    // Two stalls separate three events.
+    if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+        t.Errorf("stream took %v, want at least 60ms", elapsed)
+    }
+}
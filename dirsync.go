@@ -0,0 +1,382 @@
+package dify
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+)
+
+// DefaultManifestName is the manifest file name used when DirSync.ManifestPath is empty.
+const DefaultManifestName = ".dify-sync.json"
+
+// SyncAction is the operation DirSync performs for a single file.
+type SyncAction string
+
+const (
+    SyncCreate    SyncAction = "create"
+    SyncUpdate    SyncAction = "update"
+    SyncDelete    SyncAction = "delete"
+    SyncUnchanged SyncAction = "unchanged"
+)
+
+// DirSync mirrors a local directory into a knowledge dataset.
+// Every regular file below Root becomes one document; a JSON manifest
+// remembers which document each file maps to and the hash it was synced at.
+type DirSync struct {
+    Client    *Client
+    DatasetID string
+    Root      string
+
+    // ManifestPath is where the manifest is stored.
+    // It defaults to DefaultManifestName inside Root.
+    ManifestPath string
+
+    // Include decides whether a file, given by its slash-separated path
+    // relative to Root, is synced. By default every file is included
+    // except hidden files and files inside hidden directories.
+    Include func(relPath string) bool
+
+    // Document holds the settings used when creating or updating documents.
+    // FileName is ignored; documents are named after their relative path.
+    Document DocumentByFileRequest
+
+    // DryRun only computes the plan and prints it to Out.
+    DryRun bool
+    Out    io.Writer
+}
+
+// SyncManifest maps local files to the documents they were synced to.
+type SyncManifest struct {
+    DatasetID string                   `json:"dataset_id"`
+    Files     map[string]ManifestEntry `json:"files"`
+}
+
+// ManifestEntry records the last synced state of a single file.
+type ManifestEntry struct {
+    DocumentID string `json:"document_id"`
+    SHA256     string `json:"sha256"`
+    Size       int64  `json:"size"`
+    SyncedAt   int64  `json:"synced_at"`
+}
+
+// SyncItem is one planned operation.
+type SyncItem struct {
+    Action     SyncAction
+    Path       string
+    DocumentID string
+    SHA256     string
+    Size       int64
+}
+
+// SyncPlan lists the operations needed to bring the dataset in line with the directory.
+type SyncPlan struct {
+    Items []SyncItem
+}
+
+// Count returns the number of planned items with the given action.
+func (p *SyncPlan) Count(action SyncAction) int {
+    n := 0
+    for _, item := range p.Items {
+        if item.Action == action {
+            n++
+        }
+    }
+    return n
+}
+
+// Print writes a human readable version of the plan to w.
+func (p *SyncPlan) Print(w io.Writer) error {
+    for _, item := range p.Items {
+        if item.Action == SyncUnchanged {
+            continue
+        }
+        line := fmt.Sprintf("%-7s %s", item.Action, item.Path)
+        if item.DocumentID != "" {
+            line += fmt.Sprintf(" (document %s)", item.DocumentID)
+        }
+        if _, err := fmt.Fprintln(w, line); err != nil {
+            return err
+        }
+    }
+    _, err := fmt.Fprintf(w, "plan: %d to create, %d to update, %d to delete, %d unchanged\n",
+        p.Count(SyncCreate), p.Count(SyncUpdate), p.Count(SyncDelete), p.Count(SyncUnchanged))
+    return err
+}
+
+// Plan compares the directory with the manifest and returns the operations
+// Run would perform, without touching the dataset.
+func (s *DirSync) Plan(ctx context.Context) (*SyncPlan, error) {
+    manifest, err := s.loadManifest()
+    if err != nil {
+        return nil, err
+    }
+    return s.plan(ctx, manifest)
+}
+
+// Run synchronizes the directory into the dataset.
+// Deletions are applied first, then updates, then creations. Updated files
+// whose document was deleted remotely are created again. The manifest is
+// saved even when an operation fails, so a rerun resumes where it stopped.
+func (s *DirSync) Run(ctx context.Context) (*SyncPlan, error) {
+    manifest, err := s.loadManifest()
+    if err != nil {
+        return nil, err
+    }
+
+    plan, err := s.plan(ctx, manifest)
+    if err != nil {
+        return nil, err
+    }
+
+    if s.DryRun {
+        out := s.Out
+        if out == nil {
+            out = os.Stdout
+        }
+        return plan, plan.Print(out)
+    }
+
+    applyErr := s.apply(ctx, plan, manifest)
+    if err := s.saveManifest(manifest); err != nil && applyErr == nil {
+        applyErr = err
+    }
+    return plan, applyErr
+}
+
+// apply performs the planned operations and records them in the manifest.
+func (s *DirSync) apply(ctx context.Context, plan *SyncPlan, manifest *SyncManifest) error {
+    for _, action := range []SyncAction{SyncDelete, SyncUpdate, SyncCreate} {
+        for _, item := range plan.Items {
+            if item.Action != action {
+                continue
+            }
+            if err := ctx.Err(); err != nil {
+                return err
+            }
+
+            switch item.Action {
+            case SyncDelete:
+                // A document that is already gone, deleted remotely or by a
+                // run that failed before saving the manifest, counts as deleted.
+                err := s.Client.DeleteDocument(ctx, s.DatasetID, item.DocumentID)
+                if err != nil && !errors.Is(err, ErrNotFound) {
+                    return fmt.Errorf("delete %s: %w", item.Path, err)
+                }
+                delete(manifest.Files, item.Path)
+                continue
+            case SyncUpdate:
+                docResp, err := s.Client.UpdateDocumentByFile(ctx, s.DatasetID, item.DocumentID, s.localPath(item.Path), s.documentRequest(item.Path))
+                if errors.Is(err, ErrNotFound) {
+                    // The document was deleted remotely; create it again
+                    // and record its new ID.
+                    docResp, err = s.Client.CreateDocumentByFile(ctx, s.DatasetID, s.localPath(item.Path), s.documentRequest(item.Path))
+                }
+                if err != nil {
+                    return fmt.Errorf("update %s: %w", item.Path, err)
+                }
+                item.DocumentID = docResp.Document.ID
+            case SyncCreate:
+                docResp, err := s.Client.CreateDocumentByFile(ctx, s.DatasetID, s.localPath(item.Path), s.documentRequest(item.Path))
+                if err != nil {
+                    return fmt.Errorf("create %s: %w", item.Path, err)
+                }
+                item.DocumentID = docResp.Document.ID
+            }
+
+            manifest.Files[item.Path] = ManifestEntry{
+                DocumentID: item.DocumentID,
+                SHA256:     item.SHA256,
+                Size:       item.Size,
+                SyncedAt:   time.Now().Unix(),
+            }
+        }
+    }
+    return nil
+}
+
+// plan walks the directory and diffs it against the manifest.
+func (s *DirSync) plan(ctx context.Context, manifest *SyncManifest) (*SyncPlan, error) {
+    manifestPath, err := filepath.Abs(s.manifestPath())
+    if err != nil {
+        return nil, err
+    }
+
+    plan := &SyncPlan{}
+    seen := make(map[string]bool)
+    err = filepath.WalkDir(s.Root, func(path string, d fs.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+
+        rel, err := filepath.Rel(s.Root, path)
+        if err != nil {
+            return err
+        }
+        rel = filepath.ToSlash(rel)
+        if rel == "." {
+            return nil
+        }
+
+        if d.IsDir() {
+            if s.Include == nil && strings.HasPrefix(d.Name(), ".") {
+                return filepath.SkipDir
+            }
+            return nil
+        }
+        if !d.Type().IsRegular() || !s.include(rel) {
+            return nil
+        }
+        if abs, err := filepath.Abs(path); err == nil && abs == manifestPath {
+            return nil
+        }
+
+        sum, size, err := hashFile(path)
+        if err != nil {
+            return err
+        }
+        seen[rel] = true
+
+        item := SyncItem{Path: rel, SHA256: sum, Size: size}
+        entry, ok := manifest.Files[rel]
+        switch {
+        case !ok:
+            item.Action = SyncCreate
+        case entry.SHA256 != sum:
+            item.Action = SyncUpdate
+            item.DocumentID = entry.DocumentID
+        default:
+            item.Action = SyncUnchanged
+            item.DocumentID = entry.DocumentID
+        }
+        plan.Items = append(plan.Items, item)
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    for rel, entry := range manifest.Files {
+        if seen[rel] {
+            continue
+        }
+        plan.Items = append(plan.Items, SyncItem{
+            Action:     SyncDelete,
+            Path:       rel,
+            DocumentID: entry.DocumentID,
+        })
+    }
+
+    sort.Slice(plan.Items, func(i, j int) bool {
+        return plan.Items[i].Path < plan.Items[j].Path
+    })
+    return plan, nil
+}
+
+// include applies the Include filter or the default hidden-file rule.
+func (s *DirSync) include(rel string) bool {
+    if s.Include != nil {
+        return s.Include(rel)
+    }
+    return !strings.HasPrefix(filepath.Base(rel), ".")
+}
+
+// documentRequest returns the document settings for a file.
+func (s *DirSync) documentRequest(rel string) DocumentByFileRequest {
+    reqBody := s.Document
+    reqBody.FileName = rel
+    return reqBody
+}
+
+// localPath converts a manifest path back into a file system path.
+func (s *DirSync) localPath(rel string) string {
+    return filepath.Join(s.Root, filepath.FromSlash(rel))
+}
+
+// manifestPath returns the configured or default manifest location.
+func (s *DirSync) manifestPath() string {
+    if s.ManifestPath != "" {
+        return s.ManifestPath
+    }
+    return filepath.Join(s.Root, DefaultManifestName)
+}
+
+// loadManifest reads the manifest, returning an empty one if it does not exist yet.
+func (s *DirSync) loadManifest() (*SyncManifest, error) {
+    manifest := &SyncManifest{
+        DatasetID: s.DatasetID,
+        Files:     make(map[string]ManifestEntry),
+    }
+
+    data, err := os.ReadFile(s.manifestPath())
+    if errors.Is(err, fs.ErrNotExist) {
+        return manifest, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    if err := json.Unmarshal(data, manifest); err != nil {
+        return nil, fmt.Errorf("read manifest %s: %w", s.manifestPath(), err)
+    }
+    if manifest.Files == nil {
+        manifest.Files = make(map[string]ManifestEntry)
+    }
+    if manifest.DatasetID != s.DatasetID && len(manifest.Files) > 0 {
+        return nil, fmt.Errorf("manifest %s belongs to dataset %s, not %s", s.manifestPath(), manifest.DatasetID, s.DatasetID)
+    }
+    manifest.DatasetID = s.DatasetID
+    return manifest, nil
+}
+
+// saveManifest writes the manifest atomically next to its final location.
+func (s *DirSync) saveManifest(manifest *SyncManifest) error {
+    data, err := json.MarshalIndent(manifest, "", "  ")
+    if err != nil {
+        return err
+    }
+
+    path := s.manifestPath()
+    tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), path)
+}
+
+// hashFile returns the hex SHA-256 digest and size of a file.
+func hashFile(path string) (string, int64, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return "", 0, err
+    }
+    defer file.Close()
+
+    hash := sha256.New()
+    size, err := io.Copy(hash, file)
+    if err != nil {
+        return "", 0, err
+    }
+    return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
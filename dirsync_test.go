@@ -0,0 +1,136 @@
+package dify_test
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "maps"
+    "os"
+    "path/filepath"
+    "testing"
+
+    dify "github.com/barlowliu/dify-go"
+    "github.com/barlowliu/dify-go/difytest"
+)
+
+// writeFiles writes files, given by slash-separated path, below root.
+func writeFiles(t *testing.T, root string, files map[string]string) {
+    t.Helper()
+    for rel, content := range files {
+        path := filepath.Join(root, filepath.FromSlash(rel))
+        if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+            t.Fatal(err)
+        }
+        if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+// readManifest returns the document ID of each file in the manifest of root.
+func readManifest(t *testing.T, root string) map[string]string {
+    t.Helper()
+    data, err := os.ReadFile(filepath.Join(root, dify.DefaultManifestName))
+    if err != nil {
+        t.Fatalf("reading the manifest: %v", err)
+    }
+    var manifest dify.SyncManifest
+    if err := json.Unmarshal(data, &manifest); err != nil {
+        t.Fatalf("decoding the manifest: %v", err)
+    }
+    if manifest.DatasetID != "ds-1" {
+        t.Errorf("manifest dataset = %q, want ds-1", manifest.DatasetID)
+    }
+    ids := make(map[string]string)
+    for rel, entry := range manifest.Files {
+        ids[rel] = entry.DocumentID
+    }
+    return ids
+}
+
+func assertIDs(t *testing.T, got, want map[string]string) {
+    t.Helper()
+    if !maps.Equal(got, want) {
+        t.Errorf("manifest = %v, want %v", got, want)
+    }
+}
+
+func TestDirSync(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    root := t.TempDir()
+    writeFiles(t, root, map[string]string{
+        "a.txt":       "alpha",
+        "sub/b.txt":   "bravo",
+        "notes/d.md":  "delta",
+        ".hidden":     "skipped",
+        ".git/config": "skipped",
+    })
+    sync := &dify.DirSync{Client: srv.Client(), DatasetID: "ds-1", Root: root}
+    ctx := context.Background()
+
+    plan, err := sync.Run(ctx)
+    if err != nil {
+        t.Fatalf("first Run: %v", err)
+    }
+    if plan.Count(dify.SyncCreate) != 3 || len(plan.Items) != 3 {
+        t.Errorf("first plan = %+v, want three creations", plan.Items)
+    }
+    srv.ExpectRequests(t, difytest.CreateDocumentByFile, 3)
+    assertIDs(t, readManifest(t, root), map[string]string{"a.txt": "doc-1", "notes/d.md": "doc-2", "sub/b.txt": "doc-3"})
+
+    writeFiles(t, root, map[string]string{"a.txt": "alpha 2", "c.txt": "charlie"})
+    if err := os.Remove(filepath.Join(root, "sub", "b.txt")); err != nil {
+        t.Fatal(err)
+    }
+    // Someone deletes the document of a.txt in Dify.
+    if err := sync.Client.DeleteDocument(ctx, "ds-1", "doc-1"); err != nil {
+        t.Fatalf("DeleteDocument: %v", err)
+    }
+    before := documentRequests(srv)
+
+    // A dry run prints the plan without touching the dataset.
+    var out bytes.Buffer
+    sync.DryRun, sync.Out = true, &out
+    if _, err := sync.Run(ctx); err != nil {
+        t.Fatalf("dry run: %v", err)
+    }
+    want := "update  a.txt (document doc-1)\n" +
+        "create  c.txt\n" +
+        "delete  sub/b.txt (document doc-3)\n" +
+        "plan: 1 to create, 1 to update, 1 to delete, 1 unchanged\n"
+    if out.String() != want {
+        t.Errorf("dry run printed\n%s\nwant\n%s", out.String(), want)
+    }
+    if n := documentRequests(srv) - before; n != 0 {
+        t.Errorf("dry run sent %d requests", n)
+    }
+    assertIDs(t, readManifest(t, root), map[string]string{"a.txt": "doc-1", "notes/d.md": "doc-2", "sub/b.txt": "doc-3"})
+
+    // The update of the deleted document falls back to creating it.
+    sync.DryRun = false
+    if _, err := sync.Run(ctx); err != nil {
+        t.Fatalf("Run: %v", err)
+    }
+    if n := documentRequests(srv) - before; n != 4 {
+        t.Errorf("Run sent %d requests, want a deletion, a failed update and two creations", n)
+    }
+    assertIDs(t, readManifest(t, root), map[string]string{"a.txt": "doc-4", "c.txt": "doc-5", "notes/d.md": "doc-2"})
+
+    // Once synced, a rerun has nothing to do.
+    before = documentRequests(srv)
+    plan, err = sync.Run(ctx)
+    if err != nil {
+        t.Fatalf("rerun: %v", err)
+    }
+    if plan.Count(dify.SyncUnchanged) != 3 || documentRequests(srv) != before {
+        t.Errorf("rerun planned %+v", plan.Items)
+    }
+}
+
+// documentRequests returns the number of document requests srv received.
+func documentRequests(srv *difytest.Server) int {
+    return len(srv.Requests(difytest.CreateDocumentByFile)) +
+        len(srv.Requests(difytest.UpdateDocumentByFile)) +
+        len(srv.Requests(difytest.DeleteDocument))
+}
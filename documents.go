@@ -0,0 +1,43 @@
+package dify
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+)
+
+// CreateDocumentByFile creates a new document in a dataset from a local file.
+func (c *Client) CreateDocumentByFile(ctx context.Context, datasetID, filePath string, reqBody DocumentByFileRequest) (*DocumentResponse, error) {
+    ep := endpoint{name: "CreateDocumentByFile", method: "POST", path: fmt.Sprintf("/datasets/%s/document/create-by-file", datasetID)}
+    return c.sendDocumentFile(ctx, ep, filePath, reqBody)
+}
+
+// UpdateDocumentByFile replaces the content of an existing document with a local file.
+// The document is re-indexed with the given settings.
+func (c *Client) UpdateDocumentByFile(ctx context.Context, datasetID, documentID, filePath string, reqBody DocumentByFileRequest) (*DocumentResponse, error) {
+    ep := endpoint{name: "UpdateDocumentByFile", method: "POST", path: fmt.Sprintf("/datasets/%s/documents/%s/update-by-file", datasetID, documentID)}
+    return c.sendDocumentFile(ctx, ep, filePath, reqBody)
+}
+
+// DeleteDocument deletes a document from a dataset.
+func (c *Client) DeleteDocument(ctx context.Context, datasetID, documentID string) error {
+    ep := endpoint{name: "DeleteDocument", method: "DELETE", path: fmt.Sprintf("/datasets/%s/documents/%s", datasetID, documentID)}
+    _, err := do[noBody, struct{}](ctx, c, ep, noBody{})
+    return err
+}
+
+// sendDocumentFile posts a file and its document settings as a multipart form.
+func (c *Client) sendDocumentFile(ctx context.Context, ep endpoint, filePath string, reqBody DocumentByFileRequest) (*DocumentResponse, error) {
+    // Marshal document settings
+    data, err := json.Marshal(reqBody)
+    if err != nil {
+        return nil, err
+    }
+
+    body := multipartFile{
+        path:   filePath,
+        name:   reqBody.FileName,
+        fields: map[string]string{"data": string(data)},
+    }
+    return do[multipartFile, DocumentResponse](ctx, c, ep, body)
+}
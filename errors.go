@@ -1,12 +1,82 @@
-package dify-go
+package dify
 
-import "fmt"
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+)
+
+// Sentinel errors matched by APIError with errors.Is.
+var (
+    ErrInvalidAPIKey          = errors.New("invalid api key")
+    ErrRateLimited            = errors.New("rate limited")
+    ErrQuotaExceeded          = errors.New("provider quota exceeded")
+    ErrNotFound               = errors.New("not found")
+    ErrConversationNotFound   = errors.New("conversation not found")
+    ErrConversationCompleted  = errors.New("conversation completed")
+    ErrInvalidParam           = errors.New("invalid parameter")
+    ErrAppUnavailable         = errors.New("app unavailable")
+    ErrAppModeMismatch        = errors.New("app mode does not support this endpoint")
+    ErrProviderNotInitialized = errors.New("model provider not initialized")
+    ErrModelNotSupported      = errors.New("model currently not supported")
+    ErrCompletionRequestError = errors.New("completion request error")
+    ErrNoFileUploaded         = errors.New("no file uploaded")
+    ErrTooManyFiles           = errors.New("too many files")
+    ErrFileTooLarge           = errors.New("file too large")
+    ErrUnsupportedFileType    = errors.New("unsupported file type")
+    ErrServerError            = errors.New("dify server error")
+)
+
+// errorCodes maps the error codes returned by Dify to sentinel errors.
+var errorCodes = map[string]error{
+    "unauthorized":                ErrInvalidAPIKey,
+    "too_many_requests":           ErrRateLimited,
+    "rate_limit_error":            ErrRateLimited,
+    "provider_quota_exceeded":     ErrQuotaExceeded,
+    "quota_exceeded":              ErrQuotaExceeded,
+    "not_found":                   ErrNotFound,
+    "conversation_not_exists":     ErrConversationNotFound,
+    "conversation_completed":      ErrConversationCompleted,
+    "invalid_param":               ErrInvalidParam,
+    "app_unavailable":             ErrAppUnavailable,
+    "not_chat_app":                ErrAppModeMismatch,
+    "not_completion_app":          ErrAppModeMismatch,
+    "not_workflow_app":            ErrAppModeMismatch,
+    "provider_not_initialize":     ErrProviderNotInitialized,
+    "model_currently_not_support": ErrModelNotSupported,
+    "completion_request_error":    ErrCompletionRequestError,
+    "no_file_uploaded":            ErrNoFileUploaded,
+    "too_many_files":              ErrTooManyFiles,
+    "file_too_large":              ErrFileTooLarge,
+    "unsupported_file_type":       ErrUnsupportedFileType,
+}
+
+// errorStatuses maps HTTP status codes to sentinel errors for responses
+// that carry no recognizable error code.
+var errorStatuses = map[int]error{
+    http.StatusUnauthorized:          ErrInvalidAPIKey,
+    http.StatusNotFound:              ErrNotFound,
+    http.StatusRequestEntityTooLarge: ErrFileTooLarge,
+    http.StatusUnsupportedMediaType:  ErrUnsupportedFileType,
+    http.StatusTooManyRequests:       ErrRateLimited,
+}
+
+// maxErrorBodySize bounds how much of an error response body is kept.
+const maxErrorBodySize = 64 << 10
 
 // APIError represents an error returned by the Dify API.
 type APIError struct {
-    StatusCode int    `json:"status_code"`
-    Code       string `json:"code"`
-    Message    string `json:"message"`
+    StatusCode int         `json:"status_code"`
+    Code       string      `json:"code"`
+    Message    string      `json:"message"`
+    Params     interface{} `json:"params,omitempty"`
+    // RawBody is the undecoded response body.
+    RawBody string `json:"-"`
+    // RequestID is the X-Request-Id response header, if any.
+    RequestID string `json:"-"`
 }
 
 // Error implements the error interface.
@@ -14,3 +84,76 @@ func (e *APIError) Error() string {
     return fmt.Sprintf("APIError: %s - %s (status code: %d)", e.Code, e.Message, e.StatusCode)
 }
 
+// Is reports whether the error matches a sentinel error, so callers can
+// write errors.Is(err, ErrRateLimited).
+func (e *APIError) Is(target error) bool {
+    if sentinel, ok := errorCodes[e.Code]; ok {
+        if sentinel == target {
+            return true
+        }
+        // Dify reports a missing conversation as a generic not_found.
+        if target == ErrConversationNotFound && sentinel == ErrNotFound {
+            return strings.Contains(strings.ToLower(e.Message), "conversation")
+        }
+    }
+    if sentinel, ok := errorStatuses[e.StatusCode]; ok && sentinel == target {
+        return true
+    }
+    return target == ErrServerError && e.StatusCode >= 500
+}
+
+// newAPIError builds an APIError from a non-2xx response, reading its body.
+// Bodies that are not Dify error JSON are kept as the message.
+func newAPIError(resp *http.Response) *APIError {
+    body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+
+    apiErr := &APIError{}
+    if err := json.Unmarshal(body, apiErr); err != nil || (apiErr.Code == "" && apiErr.Message == "") {
+        apiErr = &APIError{Message: strings.TrimSpace(string(body))}
+    }
+    if apiErr.Message == "" {
+        apiErr.Message = http.StatusText(resp.StatusCode)
+    }
+    apiErr.StatusCode = resp.StatusCode
+    apiErr.RawBody = string(body)
+    apiErr.RequestID = resp.Header.Get("X-Request-Id")
+    return apiErr
+}
+
+// Err returns the error carried by an "error" stream event: an APIError for
+// errors sent by Dify, or the error that ended the stream while reading it,
+// such as a network error or undecodable event. It returns nil for any
+// other event.
+func (c ChunkChatCompletionResponse) Err() error {
+    if c.err != nil {
+        return c.err
+    }
+    if c.Event != "error" {
+        return nil
+    }
+    return &APIError{StatusCode: c.Status, Code: c.Code, Message: c.Message}
+}
+
+// Err returns the error carried by an "error" stream event: an APIError for
+// errors sent by Dify, or the error that ended the stream while reading it,
+// such as a network error or undecodable event. It returns nil for any
+// other event.
+func (c ChunkCompletionResponse) Err() error {
+    if c.err != nil {
+        return c.err
+    }
+    if c.Event != "error" {
+        return nil
+    }
+    return &APIError{StatusCode: c.Status, Code: c.Code, Message: c.Message}
+}
+
+// errorEvent implements streamChunk.
+func (c ChunkChatCompletionResponse) errorEvent(err error) ChunkChatCompletionResponse {
+    return ChunkChatCompletionResponse{Event: "error", Message: err.Error(), err: err}
+}
+
+// errorEvent implements streamChunk.
+func (c ChunkCompletionResponse) errorEvent(err error) ChunkCompletionResponse {
+    return ChunkCompletionResponse{Event: "error", Message: err.Error(), err: err}
+}
@@ -0,0 +1,100 @@
+package dify
+
+// EventInfo summarizes a stream event or a blocking response for
+// observability middleware.
+type EventInfo struct {
+    // Event is the stream event name, e.g. "message_end". It is empty for
+    // blocking responses.
+    Event          string
+    TaskID         string
+    ConversationID string
+    MessageID      string
+    WorkflowRunID  string
+    // Text reports whether the event carries generated text, so the first
+    // such event marks the time to first token.
+    Text bool
+    // Usage is the token usage reported by the event, if any. Workflow
+    // events only report total tokens.
+    Usage *Usage
+    // Err is the error carried by an "error" event.
+    Err error
+}
+
+// DescribeEvent summarizes a value passed to a Call's OnEvent or OnComplete
+// hooks, such as a ChunkChatCompletionResponse or a *ChatCompletionResponse.
+// Values of other types yield a zero EventInfo.
+func DescribeEvent(v any) EventInfo {
+    switch v := v.(type) {
+    case ChunkChatCompletionResponse:
+        info := EventInfo{
+            Event:          v.Event,
+            TaskID:         v.TaskID,
+            ConversationID: v.ConversationID,
+            MessageID:      v.MessageID,
+            Text:           (v.Event == "message" || v.Event == "agent_message") && v.Answer != "",
+            Err:            v.Err(),
+        }
+        if v.Event == "message_end" && v.Metadata != nil {
+            info.Usage = &v.Metadata.Usage
+        }
+        return info
+    case ChunkCompletionResponse:
+        info := EventInfo{
+            Event:         v.Event,
+            TaskID:        v.TaskID,
+            WorkflowRunID: v.WorkflowRunID,
+            Text:          v.Event == "text_chunk" && v.Data != nil && v.Data.Text != "",
+            Err:           v.Err(),
+        }
+        if v.Event == "workflow_finished" && v.Data != nil {
+            info.Usage = &Usage{TotalTokens: v.Data.TotalTokens}
+        }
+        return info
+    case *ChatCompletionResponse:
+        return EventInfo{
+            ConversationID: v.ConversationID,
+            MessageID:      v.MessageID,
+            Usage:          &v.Metadata.Usage,
+        }
+    case *CompletionResponse:
+        return EventInfo{
+            MessageID: v.ID,
+            Usage:     &v.Metadata.Usage,
+        }
+    case *WorkflowCompletionResponse:
+        return EventInfo{
+            TaskID:        v.TaskID,
+            WorkflowRunID: v.WorkflowRunID,
+            Usage:         &Usage{TotalTokens: v.Data.TotalTokens},
+        }
+    }
+    return EventInfo{}
+}
+
+// User returns the end-user identifier the call is made for, if any.
+func (call *Call) User() string {
+    switch req := call.Request.(type) {
+    case ChatMessageRequest:
+        return req.User
+    case CompletionMessageRequest:
+        return req.User
+    case WorkflowRunRequest:
+        return req.User
+    case map[string]string:
+        return req["user"]
+    case map[string]interface{}:
+        user, _ := req["user"].(string)
+        return user
+    case multipartFile:
+        return req.fields["user"]
+    }
+    return call.Query.Get("user")
+}
+
+// ConversationID returns the conversation a chat call continues, if any.
+func (call *Call) ConversationID() string {
+    if req, ok := call.Request.(ChatMessageRequest); ok {
+        return req.ConversationID
+    }
+    return ""
+}
@@ -6,27 +6,27 @@ import (
     "log"
     "time"
 
-    "github.com/barlowliu/dify-go.go"
+    dify "github.com/barlowliu/dify-go"
 )
 
 func main() {
     // 初始化客户端
-    client := dify-go.NewClient("https://dify-go.ai/v1", "your_api_key_here")
+    client := dify.NewClient("https://api.dify.ai/v1", "your_api_key_here")
 
     // 设置超时时间
     client.SetTimeout(30 * time.Second)
 
     // 发送对话消息（阻塞模式）
-    chatReq := dify-go.ChatMessageRequest{
+    chatReq := dify.ChatMessageRequest{
         Query:         "What are the specs of the iPhone 13 Pro Max?",
         ResponseMode:  "blocking",
         User:          "abc-123",
         ConversationID: "",
-        Files: []dify-go.FileUploadInfo{
+        Files: []dify.FileUploadInfo{
             {
                 Type:           "image",
                 TransferMethod: "remote_url",
-                URL:            "https://cloud.dify-go.ai/logo/logo-site.png",
+                URL:            "https://cloud.dify.ai/logo/logo-site.png",
             },
         },
     }
@@ -47,7 +47,7 @@ func main() {
     fmt.Printf("Uploaded File: %+v\n", uploadResp)
 
     // 执行工作流（流式模式）
-    workflowReq := dify-go.WorkflowRunRequest{
+    workflowReq := dify.WorkflowRunRequest{
         Inputs:       map[string]interface{}{},
         ResponseMode: "streaming",
         User:         "abc-123",
@@ -1,3 +1,10 @@
 module github.com/barlowliu/dify-go
 
 go 1.23.2
+
+require (
+	github.com/hashicorp/go-retryablehttp v0.7.8
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require github.com/hashicorp/go-cleanhttp v0.5.2 // indirect
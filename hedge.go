@@ -0,0 +1,261 @@
+package dify
+
+import (
+    "context"
+    "io"
+    "slices"
+    "strings"
+    "sync"
+    "time"
+)
+
+// HedgePolicy configures hedging of blocking SendCompletionMessage calls.
+// When a call has not finished after the Percentile of recent call
+// latencies, an identical second request is sent and the first to finish
+// wins. The loser is stopped with StopCompletionMessage once its task ID is
+// known. Since a hedge may double the tokens a call consumes, at most
+// MaxRatio of calls are hedged.
+type HedgePolicy struct {
+    // Percentile of recent latencies after which a call is hedged, in
+    // (0, 1]. Defaults to 0.95.
+    Percentile float64
+    // MinDelay is the shortest hedge delay. It is also the delay used until
+    // MinSamples latencies have been observed. Defaults to one second.
+    MinDelay time.Duration
+    // MinSamples is the number of latencies needed before Percentile
+    // applies. Defaults to 20.
+    MinSamples int
+    // Window is the number of recent latencies kept. Defaults to 200.
+    Window int
+    // MaxRatio is the largest fraction of calls that may be hedged.
+    // Defaults to 0.05.
+    MaxRatio float64
+}
+
+// hedgeStopTimeout bounds stopping a losing request.
+const hedgeStopTimeout = 10 * time.Second
+
+// hedger holds the latency window and hedge budget of a client.
+type hedger struct {
+    policy HedgePolicy
+
+    mu        sync.Mutex
+    latencies []time.Duration
+    next      int
+    budget    float64
+}
+
+// newHedger applies the policy defaults.
+func newHedger(policy HedgePolicy) *hedger {
+    if policy.Percentile <= 0 || policy.Percentile > 1 {
+        policy.Percentile = 0.95
+    }
+    if policy.MinDelay <= 0 {
+        policy.MinDelay = time.Second
+    }
+    if policy.MinSamples <= 0 {
+        policy.MinSamples = 20
+    }
+    if policy.Window <= 0 {
+        policy.Window = 200
+    }
+    if policy.MaxRatio <= 0 {
+        policy.MaxRatio = 0.05
+    }
+    return &hedger{policy: policy}
+}
+
+// delay returns how long to wait before hedging a call, and earns the call
+// its share of the hedge budget.
+func (h *hedger) delay() time.Duration {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    // Allow short bursts of hedges while keeping the long-run ratio.
+    h.budget = min(h.budget+h.policy.MaxRatio, max(1, h.policy.MaxRatio*10))
+
+    if len(h.latencies) < h.policy.MinSamples {
+        return h.policy.MinDelay
+    }
+    sorted := slices.Clone(h.latencies)
+    slices.Sort(sorted)
+    i := int(float64(len(sorted)-1) * h.policy.Percentile)
+    return max(sorted[i], h.policy.MinDelay)
+}
+
+// allow spends the budget of one hedge, if available.
+func (h *hedger) allow() bool {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    if h.budget < 1 {
+        return false
+    }
+    h.budget--
+    return true
+}
+
+// observe records the latency of a finished call.
+func (h *hedger) observe(latency time.Duration) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    if len(h.latencies) < h.policy.Window {
+        h.latencies = append(h.latencies, latency)
+        return
+    }
+    h.latencies[h.next] = latency
+    h.next = (h.next + 1) % h.policy.Window
+}
+
+// hedgeAttempt is one of the requests of a hedged call.
+type hedgeAttempt struct {
+    cancel context.CancelFunc
+    start  time.Time
+
+    mu     sync.Mutex
+    taskID string
+    lost   bool
+}
+
+// hedgeResult is the outcome of a hedgeAttempt.
+type hedgeResult struct {
+    attempt *hedgeAttempt
+    resp    *CompletionResponse
+    err     error
+}
+
+// hedgedCompletion sends a blocking completion as up to two streaming
+// requests and assembles the response of the first to finish.
+func (c *Client) hedgedCompletion(ctx context.Context, reqBody CompletionMessageRequest) (*CompletionResponse, error) {
+    reqBody.ResponseMode = "streaming"
+    results := make(chan hedgeResult, 2)
+
+    launch := func() *hedgeAttempt {
+        attemptCtx, cancel := context.WithCancel(ctx)
+        attempt := &hedgeAttempt{cancel: cancel, start: time.Now()}
+        go func() {
+            resp, err := c.completionAttempt(attemptCtx, reqBody, attempt)
+            results <- hedgeResult{attempt: attempt, resp: resp, err: err}
+        }()
+        return attempt
+    }
+
+    attempts := []*hedgeAttempt{launch()}
+    timer := time.NewTimer(c.hedge.delay())
+    defer timer.Stop()
+
+    var firstErr error
+    for pending := 1; pending > 0; {
+        select {
+        case <-timer.C:
+            if c.hedge.allow() {
+                attempts = append(attempts, launch())
+                pending++
+            }
+        case result := <-results:
+            pending--
+            if result.err == nil {
+                c.hedge.observe(time.Since(result.attempt.start))
+                for _, attempt := range attempts {
+                    if attempt != result.attempt {
+                        c.stopHedgeLoser(attempt, reqBody.User)
+                    }
+                }
+                result.attempt.cancel()
+                return result.resp, nil
+            }
+            result.attempt.cancel()
+            attempts = slices.DeleteFunc(attempts, func(attempt *hedgeAttempt) bool {
+                return attempt == result.attempt
+            })
+            if firstErr == nil {
+                firstErr = result.err
+            }
+            if pending == 0 {
+                return nil, firstErr
+            }
+        }
+    }
+    return nil, firstErr
+}
+
+// completionAttempt reads a completion stream into a CompletionResponse.
+func (c *Client) completionAttempt(ctx context.Context, reqBody CompletionMessageRequest, attempt *hedgeAttempt) (*CompletionResponse, error) {
+    ep := endpoint{name: "SendCompletionMessage", method: "POST", path: "/completion-messages"}
+    streamChan, err := stream[ChunkChatCompletionResponse](ctx, c, ep, reqBody)
+    if err != nil {
+        return nil, err
+    }
+
+    resp := &CompletionResponse{}
+    var answer strings.Builder
+    var streamErr error
+    ended := false
+    for chunk := range streamChan {
+        if chunk.TaskID != "" {
+            c.setHedgeTaskID(attempt, chunk.TaskID, reqBody.User)
+        }
+        if err := chunk.Err(); err != nil && streamErr == nil {
+            streamErr = err
+        }
+        switch chunk.Event {
+        case "message":
+            answer.WriteString(chunk.Answer)
+            if resp.ID == "" {
+                resp.ID = chunk.MessageID
+                resp.CreatedAt = chunk.CreatedAt
+            }
+        case "message_end":
+            ended = true
+            if chunk.Metadata != nil {
+                resp.Metadata = *chunk.Metadata
+            }
+        }
+    }
+    switch {
+    case streamErr != nil:
+        return nil, streamErr
+    case !ended && ctx.Err() != nil:
+        return nil, ctx.Err()
+    case !ended:
+        return nil, io.ErrUnexpectedEOF
+    }
+    resp.Answer = answer.String()
+    return resp, nil
+}
+
+// setHedgeTaskID records the task ID of an attempt, stopping it if it has
+// already lost.
+func (c *Client) setHedgeTaskID(attempt *hedgeAttempt, taskID, user string) {
+    attempt.mu.Lock()
+    stop := attempt.taskID == "" && attempt.lost
+    attempt.taskID = taskID
+    attempt.mu.Unlock()
+    if stop {
+        c.stopHedgeTask(attempt, taskID, user)
+    }
+}
+
+// stopHedgeLoser marks an attempt as lost and stops it if its task ID is
+// known. Otherwise it is stopped once the ID arrives.
+func (c *Client) stopHedgeLoser(attempt *hedgeAttempt, user string) {
+    attempt.mu.Lock()
+    attempt.lost = true
+    taskID := attempt.taskID
+    attempt.mu.Unlock()
+    if taskID != "" {
+        c.stopHedgeTask(attempt, taskID, user)
+        return
+    }
+    // Give up on the stop if the task ID does not arrive in time.
+    time.AfterFunc(hedgeStopTimeout, attempt.cancel)
+}
+
+// stopHedgeTask stops the task of a losing attempt in the background and
+// closes its stream.
+func (c *Client) stopHedgeTask(attempt *hedgeAttempt, taskID, user string) {
+    attempt.cancel()
+    go func() {
+        ctx, cancel := context.WithTimeout(context.Background(), hedgeStopTimeout)
+        defer cancel()
+        c.StopCompletionMessage(ctx, taskID, user)
+    }()
+}
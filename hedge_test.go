@@ -0,0 +1,106 @@
+package dify_test
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "testing"
+    "time"
+
+    dify "github.com/barlowliu/dify-go"
+    "github.com/barlowliu/dify-go/difytest"
+)
+
+// hedgePolicy hedges every call after 20ms.
+var hedgePolicy = dify.HedgePolicy{MinDelay: 20 * time.Millisecond, MaxRatio: 1}
+
+func sendCompletion(client *dify.Client) (*dify.CompletionResponse, error) {
+    resp, _, err := client.SendCompletionMessage(context.Background(), dify.CompletionMessageRequest{
+        Inputs:       map[string]any{"query": "hi"},
+        ResponseMode: "blocking",
+        User:         "alice",
+    })
+    return resp, err
+}
+
+// waitForRequests waits up to a second for n requests to route.
+func waitForRequests(t *testing.T, srv *difytest.Server, route difytest.Route, n int) []difytest.Request {
+    t.Helper()
+    deadline := time.Now().Add(time.Second)
+    for len(srv.Requests(route)) < n && time.Now().Before(deadline) {
+        time.Sleep(5 * time.Millisecond)
+    }
+    return srv.ExpectRequests(t, route, n)
+}
+
+func TestHedgeReturnsWinnerAndStopsLoser(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    usage := dify.Usage{TotalTokens: 3}
+    srv.On(difytest.CompletionMessages,
+        difytest.Stream(difytest.Message("slow").After(200*time.Millisecond), difytest.MessageEnd(usage)),
+        difytest.Stream(difytest.ChatEvents("fast answer", usage)...),
+    )
+    client := srv.Client(dify.WithHedging(hedgePolicy))
+
+    resp, err := sendCompletion(client)
+    if err != nil {
+        t.Fatalf("SendCompletionMessage: %v", err)
+    }
+    if resp.Answer != "fast answer" || resp.Metadata.Usage.TotalTokens != 3 {
+        t.Errorf("response = %+v, want the hedge's answer", resp)
+    }
+    for _, req := range srv.ExpectRequests(t, difytest.CompletionMessages, 2) {
+        if !req.Streaming() {
+            t.Errorf("attempt sent in blocking mode: %s", req.Body)
+        }
+    }
+
+    // The loser is stopped once its first event reveals its task ID.
+    if stops := srv.Requests(difytest.StopCompletionMessage); len(stops) != 0 {
+        t.Errorf("stopped %d tasks before the loser's task ID arrived", len(stops))
+    }
+    stop := waitForRequests(t, srv, difytest.StopCompletionMessage, 1)[0]
+    if stop.PathValues["task_id"] != "task-1" || stop.User() != "alice" {
+        t.Errorf("stopped task %q for %q, want task-1 for alice", stop.PathValues["task_id"], stop.User())
+    }
+}
+
+func TestHedgeMaxRatio(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    srv.Handle(difytest.CompletionMessages, func(difytest.Request) difytest.Response {
+        return difytest.Stream(difytest.Message("ok").After(60*time.Millisecond), difytest.MessageEnd(dify.Usage{}))
+    })
+    policy := hedgePolicy
+    policy.MaxRatio = 0.25
+    client := srv.Client(dify.WithHedging(policy))
+
+    for i := range 8 {
+        if _, err := sendCompletion(client); err != nil {
+            t.Fatalf("call %d: %v", i+1, err)
+        }
+    }
+    // Every fourth call earns a hedge.
+    srv.ExpectRequests(t, difytest.CompletionMessages, 10)
+    waitForRequests(t, srv, difytest.StopCompletionMessage, 2)
+}
+
+func TestHedgeReturnsFirstError(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    first := difytest.Error(http.StatusInternalServerError, "internal_server_error", "first")
+    first.Delay = 50 * time.Millisecond
+    second := difytest.Error(http.StatusBadRequest, "invalid_param", "second")
+    second.Delay = 100 * time.Millisecond
+    srv.On(difytest.CompletionMessages, first, second)
+    client := srv.Client(dify.WithHedging(hedgePolicy))
+
+    _, err := sendCompletion(client)
+    var apiErr *dify.APIError
+    if !errors.As(err, &apiErr) || apiErr.Message != "first" {
+        t.Fatalf("error = %v, want the first attempt's error", err)
+    }
+    srv.ExpectRequests(t, difytest.CompletionMessages, 2)
+    srv.ExpectScriptsUsed(t)
+}
@@ -0,0 +1,142 @@
+package dify
+
+import (
+    "errors"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// ErrNoAvailableKey is returned when every key of a KeyPool is quarantined.
+var ErrNoAvailableKey = errors.New("no available api key")
+
+// KeySelection chooses among the available keys of a KeyPool.
+type KeySelection int
+
+const (
+    // RoundRobin uses the available keys in turn.
+    RoundRobin KeySelection = iota
+    // LeastInFlight uses the available key with the fewest calls in flight.
+    LeastInFlight
+)
+
+// KeyPool spreads calls over several API keys of the same app. A key whose
+// call is answered with 401 or 429 is quarantined for CoolDown and the call
+// is retried once with each other available key. Install it with
+// WithKeyPool; a pool may be shared by several clients.
+type KeyPool struct {
+    // CoolDown is how long a key stays quarantined. Defaults to one minute.
+    CoolDown time.Duration
+    // OnQuarantine, if set, is called when a key is quarantined.
+    OnQuarantine func(key string, until time.Time, err error)
+
+    selection KeySelection
+    mu        sync.Mutex
+    keys      []*poolKey
+    next      int
+}
+
+// poolKey is a key of a KeyPool and its usage.
+type poolKey struct {
+    key              string
+    inFlight         int
+    quarantinedUntil time.Time
+}
+
+// NewKeyPool returns a pool of the given keys.
+func NewKeyPool(selection KeySelection, keys ...string) *KeyPool {
+    pool := &KeyPool{selection: selection}
+    for _, key := range keys {
+        pool.keys = append(pool.keys, &poolKey{key: key})
+    }
+    return pool
+}
+
+// Len returns the number of keys in the pool.
+func (p *KeyPool) Len() int {
+    return len(p.keys)
+}
+
+// Available returns the number of keys not in quarantine.
+func (p *KeyPool) Available() int {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    now := time.Now()
+    n := 0
+    for _, k := range p.keys {
+        if !now.Before(k.quarantinedUntil) {
+            n++
+        }
+    }
+    return n
+}
+
+// acquire selects an available key and counts a call in flight on it.
+// Keys in tried are skipped. The search for the next call starts after the
+// chosen key, so that round robin serves each available key once per cycle.
+func (p *KeyPool) acquire(tried map[*poolKey]bool) (*poolKey, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    now := time.Now()
+    var chosen *poolKey
+    chosenIndex := 0
+    for i := range p.keys {
+        index := (p.next + i) % len(p.keys)
+        k := p.keys[index]
+        if tried[k] || now.Before(k.quarantinedUntil) {
+            continue
+        }
+        if chosen == nil || k.inFlight < chosen.inFlight {
+            chosen, chosenIndex = k, index
+            if p.selection == RoundRobin {
+                break
+            }
+        }
+    }
+    if chosen == nil {
+        return nil, ErrNoAvailableKey
+    }
+    p.next = chosenIndex + 1
+    chosen.inFlight++
+    return chosen, nil
+}
+
+// release ends a call in flight on a key.
+func (p *KeyPool) release(k *poolKey) {
+    p.mu.Lock()
+    k.inFlight--
+    p.mu.Unlock()
+}
+
+// quarantine takes a key out of rotation for CoolDown.
+func (p *KeyPool) quarantine(k *poolKey, err error) {
+    coolDown := p.CoolDown
+    if coolDown <= 0 {
+        coolDown = time.Minute
+    }
+    until := time.Now().Add(coolDown)
+
+    p.mu.Lock()
+    k.quarantinedUntil = until
+    p.mu.Unlock()
+
+    if p.OnQuarantine != nil {
+        p.OnQuarantine(k.key, until, err)
+    }
+}
+
+// rejectsKey reports whether err is a 401 or 429 response, which
+// quarantines the key used.
+func rejectsKey(err error) bool {
+    var apiErr *APIError
+    if !errors.As(err, &apiErr) {
+        return false
+    }
+    return rejectsStatus(apiErr.StatusCode)
+}
+
+// rejectsStatus reports whether a response status rejects the API key.
+func rejectsStatus(status int) bool {
+    return status == http.StatusUnauthorized || status == http.StatusTooManyRequests
+}
@@ -0,0 +1,167 @@
+package dify_test
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "slices"
+    "strings"
+    "testing"
+    "time"
+
+    dify "github.com/barlowliu/dify-go"
+    "github.com/barlowliu/dify-go/difytest"
+)
+
+// keysUsed returns the API keys of the chat requests the server received.
+func keysUsed(srv *difytest.Server) []string {
+    var keys []string
+    for _, req := range srv.Requests(difytest.ChatMessages) {
+        keys = append(keys, strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+    }
+    return keys
+}
+
+// rejectKey answers chat calls made with key with resp.
+func rejectKey(key string, resp difytest.Response) func(difytest.Request) difytest.Response {
+    return func(req difytest.Request) difytest.Response {
+        if req.Header.Get("Authorization") == "Bearer "+key {
+            return resp
+        }
+        return difytest.JSON(http.StatusOK, dify.ChatCompletionResponse{Answer: "ok"})
+    }
+}
+
+func TestKeyPoolRoundRobin(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    client := srv.Client(dify.WithKeyPool(dify.NewKeyPool(dify.RoundRobin, "a", "b", "c")))
+
+    for range 6 {
+        if err := sendChat(client, "alice"); err != nil {
+            t.Fatalf("SendChatMessage: %v", err)
+        }
+    }
+    if got, want := keysUsed(srv), []string{"a", "b", "c", "a", "b", "c"}; !slices.Equal(got, want) {
+        t.Errorf("keys = %v, want %v", got, want)
+    }
+}
+
+func TestKeyPoolLeastInFlight(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    srv.On(difytest.ChatMessages, difytest.Stream(
+        difytest.Message("Hello"),
+        difytest.Message(" world").After(10*time.Second),
+    ))
+    client := srv.Client(dify.WithKeyPool(dify.NewKeyPool(dify.LeastInFlight, "a", "b")))
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    _, events, err := client.SendChatMessage(ctx, dify.ChatMessageRequest{Query: "hi", ResponseMode: "streaming", User: "alice"})
+    if err != nil {
+        t.Fatalf("stream: %v", err)
+    }
+    <-events
+    for range 3 {
+        if err := sendChat(client, "alice"); err != nil {
+            t.Fatalf("SendChatMessage: %v", err)
+        }
+    }
+    cancel()
+    for range events {
+    }
+
+    if got, want := keysUsed(srv), []string{"a", "b", "b", "b"}; !slices.Equal(got, want) {
+        t.Errorf("keys = %v, want the idle key while the stream runs: %v", got, want)
+    }
+}
+
+func TestKeyPoolQuarantine(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    srv.Handle(difytest.ChatMessages, rejectKey("a", difytest.Error(http.StatusUnauthorized, "unauthorized", "Access token is invalid")))
+
+    pool := dify.NewKeyPool(dify.RoundRobin, "a", "b", "c")
+    pool.CoolDown = 50 * time.Millisecond
+    var quarantined []string
+    pool.OnQuarantine = func(key string, until time.Time, err error) {
+        if !errors.Is(err, dify.ErrInvalidAPIKey) || until.IsZero() {
+            t.Errorf("OnQuarantine(%q, %v, %v)", key, until, err)
+        }
+        quarantined = append(quarantined, key)
+    }
+    client := srv.Client(dify.WithKeyPool(pool))
+
+    for range 30 {
+        if err := sendChat(client, "alice"); err != nil {
+            t.Fatalf("SendChatMessage: %v", err)
+        }
+    }
+    counts := map[string]int{}
+    for _, key := range keysUsed(srv) {
+        counts[key]++
+    }
+    if counts["a"] != 1 || counts["b"] != 15 || counts["c"] != 15 {
+        t.Errorf("requests per key = %v, want a:1 and the rest split evenly", counts)
+    }
+    if !slices.Equal(quarantined, []string{"a"}) || pool.Available() != 2 {
+        t.Errorf("quarantined %v with %d keys available", quarantined, pool.Available())
+    }
+
+    // After the cool-down the key is used again.
+    time.Sleep(60 * time.Millisecond)
+    if pool.Available() != 3 {
+        t.Fatalf("Available() = %d after the cool-down, want 3", pool.Available())
+    }
+    srv.Reset()
+    for range 3 {
+        if err := sendChat(client, "alice"); err != nil {
+            t.Fatalf("SendChatMessage: %v", err)
+        }
+    }
+    if keys := keysUsed(srv); !slices.Contains(keys, "a") {
+        t.Errorf("keys after the cool-down = %v, want a re-admitted", keys)
+    }
+}
+
+func TestKeyPoolFailsOverWithoutRetrying(t *testing.T) {
+    for _, status := range []int{http.StatusUnauthorized, http.StatusTooManyRequests} {
+        t.Run(http.StatusText(status), func(t *testing.T) {
+            srv := difytest.NewServer()
+            defer srv.Close()
+            rejected := difytest.Error(status, "", "rejected")
+            rejected.Header = http.Header{"Retry-After": {"5"}}
+            srv.Handle(difytest.ChatMessages, rejectKey("a", rejected))
+            client := srv.Client(dify.WithKeyPool(dify.NewKeyPool(dify.RoundRobin, "a", "b")), dify.WithRetryPolicy(dify.DefaultRetryPolicy))
+
+            start := time.Now()
+            if err := sendChat(client, "alice"); err != nil {
+                t.Fatalf("SendChatMessage: %v", err)
+            }
+            if elapsed := time.Since(start); elapsed > time.Second {
+                t.Errorf("failover took %v, want no retry of the rejected key", elapsed)
+            }
+            if got, want := keysUsed(srv), []string{"a", "b"}; !slices.Equal(got, want) {
+                t.Errorf("keys = %v, want %v", got, want)
+            }
+        })
+    }
+}
+
+func TestKeyPoolExhausted(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    srv.Handle(difytest.ChatMessages, func(difytest.Request) difytest.Response {
+        return difytest.Error(http.StatusUnauthorized, "unauthorized", "Access token is invalid")
+    })
+    client := srv.Client(dify.WithKeyPool(dify.NewKeyPool(dify.RoundRobin, "a", "b")))
+
+    if err := sendChat(client, "alice"); !errors.Is(err, dify.ErrInvalidAPIKey) {
+        t.Errorf("call with every key rejected = %v, want ErrInvalidAPIKey", err)
+    }
+    if err := sendChat(client, "alice"); !errors.Is(err, dify.ErrNoAvailableKey) {
+        t.Errorf("call with every key quarantined = %v, want ErrNoAvailableKey", err)
+    }
+    srv.ExpectRequests(t, difytest.ChatMessages, 2)
+}
@@ -0,0 +1,500 @@
+package dify
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ErrBudgetExceeded is matched by the errors of calls rejected by a Ledger
+// budget.
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// amountScale is the number of Amount units in one currency unit.
+const amountScale = 1_000_000_000
+
+// Amount is an exact decimal amount of money in billionths of a currency
+// unit, enough for the per-token prices Dify reports.
+type Amount int64
+
+// ParseAmount parses a decimal string such as "0.0001235", optionally in
+// exponent notation such as "1.235E-4" as Dify reports tiny prices. Digits
+// beyond the ninth decimal place are truncated. An empty string is zero.
+func ParseAmount(s string) (Amount, error) {
+    s = strings.TrimSpace(s)
+    if s == "" {
+        return 0, nil
+    }
+    digits := s
+    negative := false
+    if digits[0] == '-' || digits[0] == '+' {
+        negative = digits[0] == '-'
+        digits = digits[1:]
+    }
+    exponent := 0
+    if i := strings.IndexAny(digits, "eE"); i >= 0 {
+        var err error
+        if exponent, err = strconv.Atoi(digits[i+1:]); err != nil || exponent < -maxExponent || exponent > maxExponent {
+            return 0, fmt.Errorf("invalid amount %q", s)
+        }
+        digits = digits[:i]
+    }
+    whole, frac, _ := strings.Cut(digits, ".")
+    if whole == "" && frac == "" || !isDigits(whole) || !isDigits(frac) {
+        return 0, fmt.Errorf("invalid amount %q", s)
+    }
+    whole, frac = shiftPoint(whole, frac, exponent)
+    if len(frac) > 9 {
+        frac = frac[:9]
+    }
+    frac += strings.Repeat("0", 9-len(frac))
+
+    fraction, err := strconv.ParseInt(frac, 10, 64)
+    if err != nil {
+        return 0, fmt.Errorf("invalid amount %q", s)
+    }
+    var units int64
+    if whole != "" {
+        if units, err = strconv.ParseInt(whole, 10, 64); err != nil || units > (math.MaxInt64-fraction)/amountScale {
+            return 0, fmt.Errorf("invalid amount %q", s)
+        }
+    }
+    amount := Amount(units*amountScale + fraction)
+    if negative {
+        amount = -amount
+    }
+    return amount, nil
+}
+
+// isDigits reports whether s consists of ASCII digits only.
+func isDigits(s string) bool {
+    for i := 0; i < len(s); i++ {
+        if s[i] < '0' || s[i] > '9' {
+            return false
+        }
+    }
+    return true
+}
+
+// maxExponent bounds the exponents ParseAmount accepts; larger ones
+// overflow or truncate to zero anyway.
+const maxExponent = 64
+
+// shiftPoint moves the decimal point between the whole and fractional
+// digits of a number by exponent places to the right.
+func shiftPoint(whole, frac string, exponent int) (string, string) {
+    if exponent == 0 {
+        return whole, frac
+    }
+    digits := whole + frac
+    point := len(whole) + exponent
+    if point < 0 {
+        digits = strings.Repeat("0", -point) + digits
+        point = 0
+    }
+    if point > len(digits) {
+        digits += strings.Repeat("0", point-len(digits))
+    }
+    return digits[:point], digits[point:]
+}
+
+// String formats the amount as a decimal without trailing zeros.
+func (a Amount) String() string {
+    sign := ""
+    if a < 0 {
+        sign, a = "-", -a
+    }
+    whole, frac := int64(a)/amountScale, int64(a)%amountScale
+    if frac == 0 {
+        return fmt.Sprintf("%s%d", sign, whole)
+    }
+    return strings.TrimRight(fmt.Sprintf("%s%d.%09d", sign, whole, frac), "0")
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (a Amount) MarshalText() ([]byte, error) {
+    return []byte(a.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (a *Amount) UnmarshalText(text []byte) error {
+    amount, err := ParseAmount(string(text))
+    *a = amount
+    return err
+}
+
+// Cost is the token usage and price of a call parsed from its Usage.
+type Cost struct {
+    PromptTokens     int
+    CompletionTokens int
+    TotalTokens      int
+    PromptPrice      Amount
+    CompletionPrice  Amount
+    TotalPrice       Amount
+    Currency         string
+}
+
+// ParseUsage parses the prices of a Usage into a Cost.
+func ParseUsage(usage Usage) (Cost, error) {
+    cost := Cost{
+        PromptTokens:     usage.PromptTokens,
+        CompletionTokens: usage.CompletionTokens,
+        TotalTokens:      usage.TotalTokens,
+        Currency:         usage.Currency,
+    }
+    var errs [3]error
+    cost.PromptPrice, errs[0] = ParseAmount(usage.PromptPrice)
+    cost.CompletionPrice, errs[1] = ParseAmount(usage.CompletionPrice)
+    cost.TotalPrice, errs[2] = ParseAmount(usage.TotalPrice)
+    if cost.TotalPrice == 0 {
+        cost.TotalPrice = cost.PromptPrice + cost.CompletionPrice
+    }
+    return cost, errors.Join(errs[:]...)
+}
+
+// LedgerKey identifies a row of a Ledger. In queries, empty fields match
+// every row.
+type LedgerKey struct {
+    // Day is the UTC date of the spend, formatted as 2006-01-02.
+    Day            string `json:"day"`
+    App            string `json:"app,omitempty"`
+    User           string `json:"user,omitempty"`
+    ConversationID string `json:"conversation_id,omitempty"`
+    Currency       string `json:"currency,omitempty"`
+}
+
+// matches reports whether key matches the query q.
+func (key LedgerKey) matches(q LedgerKey) bool {
+    return (q.Day == "" || q.Day == key.Day) &&
+        (q.App == "" || q.App == key.App) &&
+        (q.User == "" || q.User == key.User) &&
+        (q.ConversationID == "" || q.ConversationID == key.ConversationID) &&
+        (q.Currency == "" || q.Currency == key.Currency)
+}
+
+// Spend is the aggregated usage of a Ledger row.
+type Spend struct {
+    Calls  int    `json:"calls"`
+    Tokens int    `json:"tokens"`
+    Amount Amount `json:"amount"`
+}
+
+// add returns the sum of two spends.
+func (s Spend) add(other Spend) Spend {
+    return Spend{Calls: s.Calls + other.Calls, Tokens: s.Tokens + other.Tokens, Amount: s.Amount + other.Amount}
+}
+
+// LedgerStore persists the rows of a Ledger.
+type LedgerStore interface {
+    // Load returns the stored rows.
+    Load() (map[LedgerKey]Spend, error)
+    // Add adds spend to a row.
+    Add(key LedgerKey, spend Spend) error
+}
+
+// MemoryStore is a LedgerStore kept in memory.
+type MemoryStore struct {
+    mu   sync.Mutex
+    rows map[LedgerKey]Spend
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+    return &MemoryStore{rows: make(map[LedgerKey]Spend)}
+}
+
+// Load implements LedgerStore.
+func (s *MemoryStore) Load() (map[LedgerKey]Spend, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    rows := make(map[LedgerKey]Spend, len(s.rows))
+    for key, spend := range s.rows {
+        rows[key] = spend
+    }
+    return rows, nil
+}
+
+// Add implements LedgerStore.
+func (s *MemoryStore) Add(key LedgerKey, spend Spend) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.rows[key] = s.rows[key].add(spend)
+    return nil
+}
+
+// FileStore is a LedgerStore backed by a file with one JSON record per
+// line, appended for each call.
+type FileStore struct {
+    Path string
+
+    mu sync.Mutex
+}
+
+// NewFileStore returns a FileStore writing to path.
+func NewFileStore(path string) *FileStore {
+    return &FileStore{Path: path}
+}
+
+// fileRecord is a line of a FileStore.
+type fileRecord struct {
+    LedgerKey
+    Spend
+}
+
+// Load implements LedgerStore. A missing file holds no rows.
+func (s *FileStore) Load() (map[LedgerKey]Spend, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    rows := make(map[LedgerKey]Spend)
+    file, err := os.Open(s.Path)
+    if os.IsNotExist(err) {
+        return rows, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    scanner := bufio.NewScanner(file)
+    for line := 1; scanner.Scan(); line++ {
+        if len(strings.TrimSpace(scanner.Text())) == 0 {
+            continue
+        }
+        var record fileRecord
+        if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+            return nil, fmt.Errorf("%s:%d: %w", s.Path, line, err)
+        }
+        rows[record.LedgerKey] = rows[record.LedgerKey].add(record.Spend)
+    }
+    return rows, scanner.Err()
+}
+
+// Add implements LedgerStore.
+func (s *FileStore) Add(key LedgerKey, spend Spend) error {
+    data, err := json.Marshal(fileRecord{LedgerKey: key, Spend: spend})
+    if err != nil {
+        return err
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    file, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+    if err != nil {
+        return err
+    }
+    if _, err := file.Write(append(data, '\n')); err != nil {
+        file.Close()
+        return err
+    }
+    return file.Close()
+}
+
+// BudgetScope is what a Budget limits the spend of.
+type BudgetScope int
+
+const (
+    // BudgetPerUser limits the spend of each user.
+    BudgetPerUser BudgetScope = iota
+    // BudgetPerApp limits the spend of each app.
+    BudgetPerApp
+    // BudgetPerConversation limits the spend of each conversation.
+    BudgetPerConversation
+)
+
+// String returns the name of the scope.
+func (s BudgetScope) String() string {
+    switch s {
+    case BudgetPerUser:
+        return "user"
+    case BudgetPerApp:
+        return "app"
+    case BudgetPerConversation:
+        return "conversation"
+    }
+    return fmt.Sprintf("BudgetScope(%d)", int(s))
+}
+
+// Budget limits spend. Calls are rejected once the limit has been reached.
+type Budget struct {
+    Scope BudgetScope
+    Limit Amount
+    // Currency is the currency of Limit. If empty, the limit applies to
+    // each currency.
+    Currency string
+    // Daily makes the budget reset every UTC day; otherwise it covers all
+    // recorded spend.
+    Daily bool
+}
+
+// BudgetError is returned for calls rejected by a budget. It matches
+// ErrBudgetExceeded with errors.Is.
+type BudgetError struct {
+    Budget   Budget
+    Key      LedgerKey
+    Spent    Amount
+    Currency string
+}
+
+// Error implements the error interface.
+func (e *BudgetError) Error() string {
+    return fmt.Sprintf("%s: %s %s spent of %s for %s", ErrBudgetExceeded, e.Spent, e.Currency, e.Budget.Limit, e.Budget.Scope)
+}
+
+// Is reports whether target is ErrBudgetExceeded.
+func (e *BudgetError) Is(target error) bool {
+    return target == ErrBudgetExceeded
+}
+
+// Ledger aggregates the spend of calls per day, app, user and
+// conversation, and enforces Budgets. Install it with
+// Client.Use(ledger.Middleware()); calls that would exceed a budget fail
+// with ErrBudgetExceeded before they are sent.
+type Ledger struct {
+    // Budgets are checked before each call.
+    Budgets []Budget
+    // OnError, if set, is called when spend cannot be parsed or stored.
+    // Calls whose price cannot be parsed are still recorded, with their
+    // tokens and a zero amount.
+    OnError func(err error)
+
+    store LedgerStore
+    mu    sync.Mutex
+    rows  map[LedgerKey]Spend
+}
+
+// NewLedger returns a Ledger persisting to store, loaded with its rows.
+func NewLedger(store LedgerStore) (*Ledger, error) {
+    rows, err := store.Load()
+    if err != nil {
+        return nil, err
+    }
+    return &Ledger{store: store, rows: rows}, nil
+}
+
+// Record adds the cost of a call made on day.
+func (l *Ledger) Record(day time.Time, app, user, conversationID string, cost Cost) error {
+    key := LedgerKey{
+        Day:            day.UTC().Format(time.DateOnly),
+        App:            app,
+        User:           user,
+        ConversationID: conversationID,
+        Currency:       cost.Currency,
+    }
+    spend := Spend{Calls: 1, Tokens: cost.TotalTokens, Amount: cost.TotalPrice}
+
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if err := l.store.Add(key, spend); err != nil {
+        return err
+    }
+    l.rows[key] = l.rows[key].add(spend)
+    return nil
+}
+
+// Total returns the spend of the rows matching query, by currency.
+func (l *Ledger) Total(query LedgerKey) map[string]Spend {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    totals := make(map[string]Spend)
+    for key, spend := range l.rows {
+        if key.matches(query) {
+            totals[key.Currency] = totals[key.Currency].add(spend)
+        }
+    }
+    return totals
+}
+
+// Rows returns a copy of the ledger rows.
+func (l *Ledger) Rows() map[LedgerKey]Spend {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    rows := make(map[LedgerKey]Spend, len(l.rows))
+    for key, spend := range l.rows {
+        rows[key] = spend
+    }
+    return rows
+}
+
+// Check returns a *BudgetError if a call for app, user and conversation
+// would exceed a budget. Budgets whose scope is empty for the call, e.g. a
+// conversation budget for a new conversation, do not apply.
+func (l *Ledger) Check(now time.Time, app, user, conversationID string) error {
+    for _, budget := range l.Budgets {
+        query := LedgerKey{Currency: budget.Currency}
+        switch budget.Scope {
+        case BudgetPerUser:
+            query.User = user
+        case BudgetPerApp:
+            query.App = app
+        case BudgetPerConversation:
+            query.ConversationID = conversationID
+        }
+        if query.User == "" && query.App == "" && query.ConversationID == "" {
+            continue
+        }
+        if budget.Daily {
+            query.Day = now.UTC().Format(time.DateOnly)
+        }
+
+        for currency, spend := range l.Total(query) {
+            if spend.Amount >= budget.Limit {
+                return &BudgetError{Budget: budget, Key: query, Spent: spend.Amount, Currency: currency}
+            }
+        }
+    }
+    return nil
+}
+
+// Middleware returns a Middleware that enforces the budgets and records
+// the cost of each call once it completes.
+func (l *Ledger) Middleware() Middleware {
+    return func(next RoundTrip) RoundTrip {
+        return func(ctx context.Context, call *Call) (*http.Response, error) {
+            start := time.Now()
+            user, conversationID := call.User(), call.ConversationID()
+            if err := l.Check(start, call.App, user, conversationID); err != nil {
+                return nil, err
+            }
+
+            var usage *Usage
+            observe := func(v any) {
+                info := DescribeEvent(v)
+                if conversationID == "" {
+                    conversationID = info.ConversationID
+                }
+                if info.Usage != nil {
+                    usage = info.Usage
+                }
+            }
+            call.OnEvent(observe)
+            call.OnComplete(func(result any, _ error) {
+                if result != nil {
+                    observe(result)
+                }
+                if usage == nil {
+                    return
+                }
+                cost, err := ParseUsage(*usage)
+                if err != nil {
+                    cost.PromptPrice, cost.CompletionPrice, cost.TotalPrice = 0, 0, 0
+                    err = fmt.Errorf("price of %s call recorded as zero: %w", call.Endpoint, err)
+                }
+                err = errors.Join(err, l.Record(start, call.App, user, conversationID, cost))
+                if err != nil && l.OnError != nil {
+                    l.OnError(err)
+                }
+            })
+            return next(ctx, call)
+        }
+    }
+}
@@ -0,0 +1,217 @@
+package dify_test
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "path/filepath"
+    "testing"
+    "time"
+
+    dify "github.com/barlowliu/dify-go"
+    "github.com/barlowliu/dify-go/difytest"
+)
+
+func TestParseAmount(t *testing.T) {
+    tests := []struct {
+        in      string
+        want    dify.Amount
+        wantErr bool
+    }{
+        {in: "", want: 0},
+        {in: "0", want: 0},
+        {in: " 1.5 ", want: 1_500_000_000},
+        {in: "0.0001235", want: 123_500},
+        {in: ".5", want: 500_000_000},
+        {in: "5.", want: 5_000_000_000},
+        {in: "+3", want: 3_000_000_000},
+        {in: "-0.25", want: -250_000_000},
+        {in: "0.1234567891", want: 123_456_789},
+        {in: "0E-7", want: 0},
+        {in: "1E-7", want: 100},
+        {in: "1.235E-4", want: 123_500},
+        {in: "-2.5e+2", want: -250_000_000_000},
+        {in: "12E2", want: 1_200_000_000_000},
+        {in: "1E-12", want: 0},
+        {in: "9223372036.854775807", want: 9223372036_854775807},
+        {in: "9223372036.854775808", wantErr: true},
+        {in: "9223372036.999999999", wantErr: true},
+        {in: "99999999999999999999", wantErr: true},
+        {in: "1E100", wantErr: true},
+        {in: "1.+5", wantErr: true},
+        {in: "++1", wantErr: true},
+        {in: "-+1", wantErr: true},
+        {in: "1.-5", wantErr: true},
+        {in: "1.2.3", wantErr: true},
+        {in: "1e", wantErr: true},
+        {in: "e5", wantErr: true},
+        {in: ".", wantErr: true},
+        {in: "-", wantErr: true},
+        {in: "abc", wantErr: true},
+        {in: "1 000", wantErr: true},
+    }
+    for _, tt := range tests {
+        got, err := dify.ParseAmount(tt.in)
+        if tt.wantErr {
+            if err == nil {
+                t.Errorf("ParseAmount(%q) = %v, want an error", tt.in, got)
+            }
+            continue
+        }
+        if err != nil || got != tt.want {
+            t.Errorf("ParseAmount(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+        }
+    }
+}
+
+func TestAmountString(t *testing.T) {
+    for amount, want := range map[dify.Amount]string{
+        0:              "0",
+        1_500_000_000:  "1.5",
+        123_500:        "0.0001235",
+        -250_000_000:   "-0.25",
+        42_000_000_000: "42",
+    } {
+        if got := amount.String(); got != want {
+            t.Errorf("Amount(%d).String() = %q, want %q", int64(amount), got, want)
+        }
+    }
+}
+
+func TestParseUsage(t *testing.T) {
+    cost, err := dify.ParseUsage(dify.Usage{
+        PromptTokens:     10,
+        PromptPrice:      "0.001",
+        CompletionTokens: 5,
+        CompletionPrice:  "0.002",
+        TotalTokens:      15,
+        Currency:         "USD",
+    })
+    if err != nil {
+        t.Fatalf("ParseUsage: %v", err)
+    }
+    if cost.TotalPrice != 3_000_000 || cost.TotalTokens != 15 || cost.Currency != "USD" {
+        t.Errorf("cost = %+v, want the prompt and completion prices summed", cost)
+    }
+
+    cost, err = dify.ParseUsage(dify.Usage{TotalTokens: 15, PromptPrice: "1.+5", TotalPrice: "0.5"})
+    if err == nil {
+        t.Error("ParseUsage with a malformed price returned no error")
+    }
+    if cost.TotalPrice != 500_000_000 || cost.TotalTokens != 15 {
+        t.Errorf("cost = %+v, want the parsable fields kept", cost)
+    }
+}
+
+// pricedChat answers chat calls with a usage costing price.
+func pricedChat(price string) func(difytest.Request) difytest.Response {
+    return func(req difytest.Request) difytest.Response {
+        return difytest.JSON(http.StatusOK, dify.ChatCompletionResponse{
+            MessageID:      "msg-1",
+            ConversationID: "conv-1",
+            Answer:         "ok",
+            Metadata: dify.Metadata{Usage: dify.Usage{
+                TotalTokens: 10,
+                TotalPrice:  price,
+                Currency:    "USD",
+            }},
+        })
+    }
+}
+
+func sendChat(client *dify.Client, user string) error {
+    _, _, err := client.SendChatMessage(context.Background(), dify.ChatMessageRequest{
+        Query:        "hi",
+        ResponseMode: "blocking",
+        User:         user,
+    })
+    return err
+}
+
+func TestLedgerBudget(t *testing.T) {
+    stores := map[string]func(t *testing.T) dify.LedgerStore{
+        "memory": func(*testing.T) dify.LedgerStore { return dify.NewMemoryStore() },
+        "file": func(t *testing.T) dify.LedgerStore {
+            return dify.NewFileStore(filepath.Join(t.TempDir(), "ledger.jsonl"))
+        },
+    }
+    for name, newStore := range stores {
+        t.Run(name, func(t *testing.T) {
+            srv := difytest.NewServer()
+            defer srv.Close()
+            srv.Handle(difytest.ChatMessages, pricedChat("0.6"))
+
+            store := newStore(t)
+            ledger, err := dify.NewLedger(store)
+            if err != nil {
+                t.Fatalf("NewLedger: %v", err)
+            }
+            ledger.Budgets = []dify.Budget{{Scope: dify.BudgetPerUser, Limit: 1_000_000_000, Currency: "USD"}}
+            client := srv.Client()
+            client.Use(ledger.Middleware())
+
+            for i := range 2 {
+                if err := sendChat(client, "alice"); err != nil {
+                    t.Fatalf("call %d within budget: %v", i+1, err)
+                }
+            }
+            err = sendChat(client, "alice")
+            var budgetErr *dify.BudgetError
+            if !errors.Is(err, dify.ErrBudgetExceeded) || !errors.As(err, &budgetErr) {
+                t.Fatalf("call over budget: err = %v, want ErrBudgetExceeded", err)
+            }
+            if budgetErr.Spent != 1_200_000_000 || budgetErr.Key.User != "alice" {
+                t.Errorf("BudgetError = %+v, want 1.2 spent by alice", budgetErr)
+            }
+            srv.ExpectRequests(t, difytest.ChatMessages, 2)
+
+            if err := sendChat(client, "bob"); err != nil {
+                t.Errorf("other user: %v", err)
+            }
+
+            total := ledger.Total(dify.LedgerKey{User: "alice"})["USD"]
+            if total.Calls != 2 || total.Tokens != 20 || total.Amount != 1_200_000_000 {
+                t.Errorf("alice's total = %+v, want 2 calls, 20 tokens, 1.2", total)
+            }
+
+            // A ledger loaded from the same store keeps enforcing the budget.
+            reloaded, err := dify.NewLedger(store)
+            if err != nil {
+                t.Fatalf("NewLedger: %v", err)
+            }
+            reloaded.Budgets = ledger.Budgets
+            if err := reloaded.Check(time.Now(), "", "alice", ""); !errors.Is(err, dify.ErrBudgetExceeded) {
+                t.Errorf("reloaded Check = %v, want ErrBudgetExceeded", err)
+            }
+            if err := reloaded.Check(time.Now(), "", "bob", ""); err != nil {
+                t.Errorf("reloaded Check for bob = %v", err)
+            }
+        })
+    }
+}
+
+func TestLedgerRecordsUnparsablePrice(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    srv.Handle(difytest.ChatMessages, pricedChat("n/a"))
+
+    ledger, err := dify.NewLedger(dify.NewMemoryStore())
+    if err != nil {
+        t.Fatalf("NewLedger: %v", err)
+    }
+    var errs []error
+    ledger.OnError = func(err error) { errs = append(errs, err) }
+    client := srv.Client()
+    client.Use(ledger.Middleware())
+
+    if err := sendChat(client, "alice"); err != nil {
+        t.Fatalf("SendChatMessage: %v", err)
+    }
+    if len(errs) != 1 {
+        t.Errorf("OnError called with %v, want one error", errs)
+    }
+    total := ledger.Total(dify.LedgerKey{User: "alice"})["USD"]
+    if total.Calls != 1 || total.Tokens != 10 || total.Amount != 0 {
+        t.Errorf("total = %+v, want 1 call and 10 tokens at zero cost", total)
+    }
+}
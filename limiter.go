@@ -0,0 +1,165 @@
+package dify
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+// RateLimiter paces calls. Wait blocks until a call may proceed or ctx ends.
+type RateLimiter interface {
+    Wait(ctx context.Context) error
+}
+
+// TokenBucket is a RateLimiter allowing rate calls per second on average
+// and bursts of up to burst calls. A TokenBucket may be shared by several
+// clients, e.g. all clients using the same API key.
+type TokenBucket struct {
+    mu     sync.Mutex
+    rate   float64
+    burst  float64
+    tokens float64
+    last   time.Time
+}
+
+// NewTokenBucket returns a full TokenBucket. A burst below 1 is treated as 1.
+// It panics if rate is not positive.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+    if !(rate > 0) {
+        panic("dify: non-positive rate for NewTokenBucket")
+    }
+    b := &TokenBucket{rate: rate, burst: float64(max(burst, 1))}
+    b.tokens = b.burst
+    b.last = time.Now()
+    return b
+}
+
+// Wait implements RateLimiter. Callers are served in the order they arrive.
+func (b *TokenBucket) Wait(ctx context.Context) error {
+    b.mu.Lock()
+    now := time.Now()
+    b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+    b.last = now
+    b.tokens--
+    if b.tokens >= 0 {
+        b.mu.Unlock()
+        return nil
+    }
+    delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+    b.mu.Unlock()
+
+    timer := time.NewTimer(delay)
+    defer timer.Stop()
+    select {
+    case <-timer.C:
+        return nil
+    case <-ctx.Done():
+        // Give the reserved token back to later callers.
+        b.mu.Lock()
+        b.tokens++
+        b.mu.Unlock()
+        return ctx.Err()
+    }
+}
+
+// LimiterStats reports how calls have waited on a client's limiters.
+type LimiterStats struct {
+    // Calls is the number of calls admitted.
+    Calls int64
+    // Canceled is the number of calls whose context ended while waiting.
+    Canceled int64
+    // RateWait and ConcurrencyWait are the total time calls spent waiting
+    // for the rate limiter and for a free concurrency slot.
+    RateWait        time.Duration
+    ConcurrencyWait time.Duration
+    // MaxWait is the longest time a single call waited.
+    MaxWait time.Duration
+    // InFlight is the number of calls currently holding a concurrency slot.
+    InFlight int
+}
+
+// limitScope holds the limiters of the whole client or of one endpoint.
+type limitScope struct {
+    rate  RateLimiter
+    slots chan struct{}
+
+    mu    sync.Mutex
+    stats LimiterStats
+}
+
+// acquire waits for the scope's limiters.
+func (s *limitScope) acquire(ctx context.Context) error {
+    start := time.Now()
+    if s.rate != nil {
+        if err := s.rate.Wait(ctx); err != nil {
+            s.record(func(stats *LimiterStats) { stats.Canceled++ })
+            return err
+        }
+    }
+    rateWait := time.Since(start)
+
+    if s.slots != nil {
+        select {
+        case s.slots <- struct{}{}:
+        case <-ctx.Done():
+            s.record(func(stats *LimiterStats) { stats.Canceled++ })
+            return ctx.Err()
+        }
+    }
+    wait := time.Since(start)
+
+    s.record(func(stats *LimiterStats) {
+        stats.Calls++
+        stats.RateWait += rateWait
+        stats.ConcurrencyWait += wait - rateWait
+        stats.MaxWait = max(stats.MaxWait, wait)
+        if s.slots != nil {
+            stats.InFlight++
+        }
+    })
+    return nil
+}
+
+// release frees the concurrency slot taken by acquire.
+func (s *limitScope) release() {
+    if s.slots == nil {
+        return
+    }
+    <-s.slots
+    s.record(func(stats *LimiterStats) { stats.InFlight-- })
+}
+
+// record updates the scope's stats.
+func (s *limitScope) record(update func(stats *LimiterStats)) {
+    s.mu.Lock()
+    update(&s.stats)
+    s.mu.Unlock()
+}
+
+// acquireLimits waits for the client-wide limiters, then for those of the
+// call's endpoint. The slots taken are released when the call completes;
+// for streaming calls that is when the stream ends.
+func (c *Client) acquireLimits(ctx context.Context, call *Call) error {
+    for _, scope := range []*limitScope{c.limits[""], c.limits[call.Endpoint]} {
+        if scope == nil {
+            continue
+        }
+        if err := scope.acquire(ctx); err != nil {
+            return err
+        }
+        call.OnComplete(func(any, error) { scope.release() })
+    }
+    return nil
+}
+
+// LimiterStats returns the wait statistics of the client's limiters, keyed
+// by endpoint name. The client-wide limiters are under the empty key.
+func (c *Client) LimiterStats() map[string]LimiterStats {
+    stats := make(map[string]LimiterStats, len(c.limits))
+    for name, scope := range c.limits {
+        scope.mu.Lock()
+        stats[name] = scope.stats
+        scope.mu.Unlock()
+    }
+    return stats
+}
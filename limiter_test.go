@@ -0,0 +1,142 @@
+package dify_test
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    dify "github.com/barlowliu/dify-go"
+    "github.com/barlowliu/dify-go/difytest"
+)
+
+func TestTokenBucket(t *testing.T) {
+    bucket := dify.NewTokenBucket(20, 2)
+    ctx := context.Background()
+
+    start := time.Now()
+    for range 2 {
+        if err := bucket.Wait(ctx); err != nil {
+            t.Fatalf("Wait within burst: %v", err)
+        }
+    }
+    if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+        t.Errorf("burst waited %v", elapsed)
+    }
+    if err := bucket.Wait(ctx); err != nil {
+        t.Fatalf("Wait: %v", err)
+    }
+    if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+        t.Errorf("call beyond the burst waited %v, want about 50ms", elapsed)
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, 5*time.Millisecond)
+    defer cancel()
+    if err := bucket.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+        t.Errorf("Wait with an expiring context = %v, want DeadlineExceeded", err)
+    }
+}
+
+func TestLimiterArgumentsMustBePositive(t *testing.T) {
+    tests := map[string]func(){
+        "NewTokenBucket(0, 1)":         func() { dify.NewTokenBucket(0, 1) },
+        "NewTokenBucket(-1, 1)":        func() { dify.NewTokenBucket(-1, 1) },
+        "WithConcurrencyLimit(0)":      func() { dify.WithConcurrencyLimit(0) },
+        "WithEndpointConcurrencyLimit": func() { dify.WithEndpointConcurrencyLimit("SendChatMessage", -1) },
+    }
+    for name, fn := range tests {
+        func() {
+            defer func() {
+                if recover() == nil {
+                    t.Errorf("%s did not panic", name)
+                }
+            }()
+            fn()
+        }()
+    }
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    srv.On(difytest.ChatMessages, difytest.Response{
+        Body:  dify.ChatCompletionResponse{Answer: "slow"},
+        Delay: 50 * time.Millisecond,
+    })
+    client := srv.Client(dify.WithConcurrencyLimit(1))
+
+    errs := make(chan error, 2)
+    for range 2 {
+        go func() { errs <- sendChat(client, "alice") }()
+    }
+    for range 2 {
+        if err := <-errs; err != nil {
+            t.Fatalf("SendChatMessage: %v", err)
+        }
+    }
+
+    stats := client.LimiterStats()[""]
+    if stats.Calls != 2 || stats.InFlight != 0 {
+        t.Errorf("stats = %+v, want 2 calls and none in flight", stats)
+    }
+    if stats.ConcurrencyWait < 30*time.Millisecond {
+        t.Errorf("ConcurrencyWait = %v, want the second call to wait for the first", stats.ConcurrencyWait)
+    }
+}
+
+func TestRateLimiterCanceled(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    client := srv.Client(dify.WithEndpointRateLimiter("SendChatMessage", dify.NewTokenBucket(1, 1)))
+
+    if err := sendChat(client, "alice"); err != nil {
+        t.Fatalf("first call: %v", err)
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+    defer cancel()
+    _, _, err := client.SendChatMessage(ctx, dify.ChatMessageRequest{Query: "hi", ResponseMode: "blocking", User: "alice"})
+    if !errors.Is(err, context.DeadlineExceeded) {
+        t.Errorf("rate limited call = %v, want DeadlineExceeded", err)
+    }
+    srv.ExpectRequests(t, difytest.ChatMessages, 1)
+    if stats := client.LimiterStats()["SendChatMessage"]; stats.Calls != 1 || stats.Canceled != 1 {
+        t.Errorf("stats = %+v, want 1 call and 1 canceled", stats)
+    }
+}
+
+func TestAbandonedStreamHoldsSlotUntilCanceled(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    srv.On(difytest.ChatMessages, difytest.Stream(
+        difytest.Message("Hello"),
+        difytest.Message(" world").After(10*time.Second),
+    ))
+    client := srv.Client(dify.WithConcurrencyLimit(1))
+
+    streamCtx, cancelStream := context.WithCancel(context.Background())
+    defer cancelStream()
+    _, events, err := client.SendChatMessage(streamCtx, dify.ChatMessageRequest{Query: "hi", ResponseMode: "streaming", User: "alice"})
+    if err != nil {
+        t.Fatalf("SendChatMessage: %v", err)
+    }
+    <-events // Stop reading without canceling.
+
+    ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+    defer cancel()
+    if _, _, err := client.SendChatMessage(ctx, dify.ChatMessageRequest{Query: "hi", ResponseMode: "blocking", User: "alice"}); !errors.Is(err, context.DeadlineExceeded) {
+        t.Fatalf("call while the stream holds the slot = %v, want DeadlineExceeded", err)
+    }
+    if inFlight := client.LimiterStats()[""].InFlight; inFlight != 1 {
+        t.Errorf("InFlight = %d, want the abandoned stream's slot", inFlight)
+    }
+
+    cancelStream()
+    ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if _, _, err := client.SendChatMessage(ctx, dify.ChatMessageRequest{Query: "hi", ResponseMode: "blocking", User: "alice"}); err != nil {
+        t.Fatalf("call after canceling the stream: %v", err)
+    }
+    if inFlight := client.LimiterStats()[""].InFlight; inFlight != 0 {
+        t.Errorf("InFlight = %d after all calls ended", inFlight)
+    }
+}
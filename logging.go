@@ -0,0 +1,159 @@
+package dify
+
+import (
+    "context"
+    "errors"
+    "log/slog"
+    "net/http"
+    "slices"
+    "strings"
+    "time"
+
+    "github.com/hashicorp/go-retryablehttp"
+)
+
+// Redact selects request contents that are masked in logs. The API key is
+// always masked.
+type Redact int
+
+const (
+    // RedactQuery masks the query of chat messages.
+    RedactQuery Redact = 1 << iota
+    // RedactInputs masks the inputs of chat, completion and workflow calls.
+    RedactInputs
+)
+
+// redacted replaces masked values in logs.
+const redacted = "[REDACTED]"
+
+// callLogger logs the lifecycle of calls.
+type callLogger struct {
+    logger *slog.Logger
+    redact Redact
+}
+
+// start logs a call and registers hooks that log its outcome.
+func (l *callLogger) start(ctx context.Context, call *Call) {
+    start := time.Now()
+    attrs := []any{slog.String("endpoint", call.Endpoint)}
+    if call.App != "" {
+        attrs = append(attrs, slog.String("app", call.App))
+    }
+
+    l.logger.DebugContext(ctx, "dify: call started", append(slices.Clip(attrs),
+        slog.String("method", call.Method),
+        slog.String("path", call.Path),
+        slog.Bool("streaming", call.Streaming),
+        slog.String("user", call.User()),
+        slog.Group("request", l.requestAttrs(call)...),
+    )...)
+
+    events := make(map[string]int)
+    total := 0
+    call.OnEvent(func(event any) {
+        total++
+        events[DescribeEvent(event).Event]++
+    })
+    call.OnComplete(func(_ any, err error) {
+        attrs := append(attrs, slog.Duration("duration", time.Since(start)))
+        if call.Streaming && total > 0 {
+            counts := make([]any, 0, len(events))
+            for name, n := range events {
+                counts = append(counts, slog.Int(name, n))
+            }
+            attrs = append(attrs, slog.Int("events", total), slog.Group("event_counts", counts...))
+        }
+        if err == nil {
+            l.logger.InfoContext(ctx, "dify: call completed", attrs...)
+            return
+        }
+
+        attrs = append(attrs, slog.Any("error", err))
+        level := slog.LevelError
+        var apiErr *APIError
+        switch {
+        case errors.Is(err, context.Canceled):
+            level = slog.LevelInfo
+        case errors.As(err, &apiErr):
+            attrs = append(attrs, slog.Int("status", apiErr.StatusCode), slog.String("code", apiErr.Code))
+            if apiErr.RequestID != "" {
+                attrs = append(attrs, slog.String("request_id", apiErr.RequestID))
+            }
+            if apiErr.StatusCode < 500 {
+                level = slog.LevelWarn
+            }
+        }
+        l.logger.Log(ctx, level, "dify: call failed", attrs...)
+    })
+}
+
+// requestAttrs describes the request body, masking redacted contents.
+func (l *callLogger) requestAttrs(call *Call) []any {
+    var query string
+    var inputs map[string]interface{}
+    switch req := call.Request.(type) {
+    case ChatMessageRequest:
+        query, inputs = req.Query, req.Inputs
+    case CompletionMessageRequest:
+        inputs = req.Inputs
+    case WorkflowRunRequest:
+        inputs = req.Inputs
+    }
+
+    var attrs []any
+    if conversationID := call.ConversationID(); conversationID != "" {
+        attrs = append(attrs, slog.String("conversation_id", conversationID))
+    }
+    if query != "" {
+        if l.redact&RedactQuery != 0 {
+            attrs = append(attrs, slog.String("query", redacted))
+        } else {
+            attrs = append(attrs, slog.String("query", query))
+        }
+    }
+    if len(inputs) > 0 {
+        if l.redact&RedactInputs != 0 {
+            attrs = append(attrs, slog.String("inputs", redacted))
+        } else {
+            attrs = append(attrs, slog.Any("inputs", inputs))
+        }
+    }
+    return attrs
+}
+
+// requestLogHook logs each attempt of a request and warns about retries.
+func (l *callLogger) requestLogHook(_ retryablehttp.Logger, req *http.Request, attempt int) {
+    attrs := []any{
+        slog.String("method", req.Method),
+        slog.String("path", req.URL.Path),
+        slog.Int("attempt", attempt+1),
+    }
+    if attempt > 0 {
+        l.logger.WarnContext(req.Context(), "dify: retrying request", attrs...)
+    }
+    l.logger.DebugContext(req.Context(), "dify: sending request",
+        append(attrs, slog.Any("headers", redactHeaders(req.Header)))...)
+}
+
+// responseLogHook logs the status of each attempt.
+func (l *callLogger) responseLogHook(_ retryablehttp.Logger, resp *http.Response) {
+    l.logger.DebugContext(resp.Request.Context(), "dify: response received",
+        slog.String("method", resp.Request.Method),
+        slog.String("path", resp.Request.URL.Path),
+        slog.Int("status", resp.StatusCode),
+    )
+}
+
+// redactHeaders returns a copy of the headers with the API key masked. The
+// last characters of the key are kept to tell keys of a KeyPool apart.
+func redactHeaders(header http.Header) http.Header {
+    header = header.Clone()
+    if auth := header.Get("Authorization"); auth != "" {
+        masked := redacted
+        if key, ok := strings.CutPrefix(auth, "Bearer "); ok && len(key) > 12 {
+            masked = "Bearer " + redacted + "..." + key[len(key)-4:]
+        }
+        header.Set("Authorization", masked)
+    }
+    return header
+}
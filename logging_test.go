@@ -0,0 +1,88 @@
+package dify_test
+
+import (
+    "bytes"
+    "context"
+    "log/slog"
+    "strings"
+    "testing"
+
+    dify "github.com/barlowliu/dify-go"
+    "github.com/barlowliu/dify-go/difytest"
+)
+
+// logChat sends a chat message with a client logging at Debug level and
+// returns the log.
+func logChat(t *testing.T, apiKey string, opts ...dify.Option) string {
+    t.Helper()
+    srv := difytest.NewServer()
+    defer srv.Close()
+    var buf bytes.Buffer
+    logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+    client := dify.NewClient(srv.URL, apiKey, append([]dify.Option{dify.WithLogger(logger), dify.WithRetryPolicy(dify.NoRetry)}, opts...)...)
+
+    _, _, err := client.SendChatMessage(context.Background(), dify.ChatMessageRequest{
+        Query:        "my secret question",
+        Inputs:       map[string]any{"account": "ACME-1234"},
+        ResponseMode: "blocking",
+        User:         "alice",
+    })
+    if err != nil {
+        t.Fatalf("SendChatMessage: %v", err)
+    }
+    return buf.String()
+}
+
+func TestLoggingMasksAPIKey(t *testing.T) {
+    log := logChat(t, "app-0123456789abcdef")
+    if strings.Contains(log, "0123456789ab") {
+        t.Errorf("log contains the API key:\n%s", log)
+    }
+    if !strings.Contains(log, "Bearer [REDACTED]...cdef") {
+        t.Errorf("log does not tell the key by its last characters:\n%s", log)
+    }
+    if !strings.Contains(log, "my secret question") || !strings.Contains(log, "ACME-1234") {
+        t.Errorf("log without redaction lacks the query or inputs:\n%s", log)
+    }
+
+    // Short keys are masked entirely.
+    if log := logChat(t, "app-short"); strings.Contains(log, "short") || !strings.Contains(log, "Authorization:[[REDACTED]]") {
+        t.Errorf("log of a short key:\n%s", log)
+    }
+}
+
+func TestLoggingRedaction(t *testing.T) {
+    tests := []struct {
+        redact  dify.Redact
+        masked  []string
+        visible []string
+    }{
+        {dify.RedactQuery, []string{"my secret question"}, []string{"ACME-1234"}},
+        {dify.RedactInputs, []string{"ACME-1234"}, []string{"my secret question"}},
+        {dify.RedactQuery | dify.RedactInputs, []string{"my secret question", "ACME-1234"}, nil},
+    }
+    for _, tt := range tests {
+        log := logChat(t, "app-0123456789abcdef", dify.WithLogRedaction(tt.redact))
+        for _, s := range tt.masked {
+            if strings.Contains(log, s) {
+                t.Errorf("redact %d: log contains %q:\n%s", tt.redact, s, log)
+            }
+        }
+        for _, s := range tt.visible {
+            if !strings.Contains(log, s) {
+                t.Errorf("redact %d: log lacks %q:\n%s", tt.redact, s, log)
+            }
+        }
+    }
+}
+
+func TestClientDoesNotLogToStderr(t *testing.T) {
+    for name, client := range map[string]*dify.Client{
+        "default":     dify.NewClient("http://localhost", "app-test"),
+        "with logger": dify.NewClient("http://localhost", "app-test", dify.WithLogger(slog.Default())),
+    } {
+        if client.HTTPClient.Logger != nil {
+            t.Errorf("%s client logs requests through retryablehttp", name)
+        }
+    }
+}
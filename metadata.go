@@ -0,0 +1,67 @@
+package dify
+
+import (
+    "context"
+    "fmt"
+)
+
+// The knowledge endpoints below are authenticated with a dataset API key
+// rather than an app key, so they are usually called on a dedicated Client.
+
+// CreateDatasetMetadata creates a custom metadata field on a dataset.
+func (c *Client) CreateDatasetMetadata(ctx context.Context, datasetID string, reqBody DatasetMetadataRequest) (*DatasetMetadata, error) {
+    ep := endpoint{name: "CreateDatasetMetadata", method: "POST", path: fmt.Sprintf("/datasets/%s/metadata", datasetID)}
+    return do[DatasetMetadataRequest, DatasetMetadata](ctx, c, ep, reqBody)
+}
+
+// UpdateDatasetMetadata renames a custom metadata field of a dataset.
+func (c *Client) UpdateDatasetMetadata(ctx context.Context, datasetID, metadataID, name string) (*DatasetMetadata, error) {
+    ep := endpoint{name: "UpdateDatasetMetadata", method: "PATCH", path: fmt.Sprintf("/datasets/%s/metadata/%s", datasetID, metadataID)}
+
+    // Prepare request body
+    body := map[string]string{
+        "name": name,
+    }
+    return do[map[string]string, DatasetMetadata](ctx, c, ep, body)
+}
+
+// DeleteDatasetMetadata deletes a custom metadata field from a dataset.
+// The field is also removed from every document that uses it.
+func (c *Client) DeleteDatasetMetadata(ctx context.Context, datasetID, metadataID string) error {
+    ep := endpoint{name: "DeleteDatasetMetadata", method: "DELETE", path: fmt.Sprintf("/datasets/%s/metadata/%s", datasetID, metadataID)}
+    _, err := do[noBody, struct{}](ctx, c, ep, noBody{})
+    return err
+}
+
+// SetBuiltInMetadata enables or disables the built-in metadata fields
+// (document name, uploader, upload date, ...) of a dataset.
+func (c *Client) SetBuiltInMetadata(ctx context.Context, datasetID string, enabled bool) error {
+    action := "disable"
+    if enabled {
+        action = "enable"
+    }
+    ep := endpoint{name: "SetBuiltInMetadata", method: "POST", path: fmt.Sprintf("/datasets/%s/metadata/built-in/%s", datasetID, action)}
+    _, err := do[noBody, struct{}](ctx, c, ep, noBody{})
+    return err
+}
+
+// ListDatasetMetadata lists the custom metadata fields of a dataset and
+// reports whether built-in metadata is enabled.
+func (c *Client) ListDatasetMetadata(ctx context.Context, datasetID string) (*DatasetMetadataListResponse, error) {
+    ep := endpoint{name: "ListDatasetMetadata", method: "GET", path: fmt.Sprintf("/datasets/%s/metadata", datasetID)}
+    return do[noBody, DatasetMetadataListResponse](ctx, c, ep, noBody{})
+}
+
+// GetBuiltInMetadataFields lists the built-in metadata fields available to a dataset.
+func (c *Client) GetBuiltInMetadataFields(ctx context.Context, datasetID string) (*BuiltInMetadataFieldsResponse, error) {
+    ep := endpoint{name: "GetBuiltInMetadataFields", method: "GET", path: fmt.Sprintf("/datasets/%s/metadata/built-in", datasetID)}
+    return do[noBody, BuiltInMetadataFieldsResponse](ctx, c, ep, noBody{})
+}
+
+// UpdateDocumentsMetadata sets metadata values on one or more documents of a dataset.
+// Each operation replaces the full metadata list of its document.
+func (c *Client) UpdateDocumentsMetadata(ctx context.Context, datasetID string, reqBody DocumentMetadataUpdateRequest) error {
+    ep := endpoint{name: "UpdateDocumentsMetadata", method: "POST", path: fmt.Sprintf("/datasets/%s/documents/metadata", datasetID)}
+    _, err := do[DocumentMetadataUpdateRequest, struct{}](ctx, c, ep, reqBody)
+    return err
+}
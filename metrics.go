@@ -0,0 +1,313 @@
+package dify
+
+import (
+    "bufio"
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "slices"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// DefaultLatencyBuckets are the histogram buckets of Metrics, in seconds.
+var DefaultLatencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// Metrics collects call metrics and exposes them in the Prometheus text
+// exposition format, without depending on the Prometheus client library.
+// Install it with Client.Use(metrics.Middleware()) and serve it as an
+// http.Handler, or write it with WriteTo.
+//
+// The following metrics are recorded, labeled by endpoint, app and, where
+// relevant, outcome ("ok", "error" or "canceled"):
+//
+//    dify_requests_total                  calls made
+//    dify_request_duration_seconds        time to the response, or to the
+//                                         start of the stream; streams
+//                                         failing to start count here
+//    dify_time_to_first_token_seconds     time to the first generated text
+//    dify_stream_duration_seconds         duration of streams
+//    dify_tokens_total                    tokens used, by type
+//    dify_price_total                     price reported in Usage, by currency
+type Metrics struct {
+    // Buckets are the histogram buckets, in any order. Defaults to
+    // DefaultLatencyBuckets. Series keep the buckets they were created with.
+    Buckets []float64
+
+    mu       sync.Mutex
+    families map[string]*metricFamily
+}
+
+// metricFamily is a metric and its series.
+type metricFamily struct {
+    name   string
+    help   string
+    kind   string
+    series map[string]*metricSeries
+}
+
+// metricSeries is a counter value, or the state of a histogram.
+type metricSeries struct {
+    value   float64
+    bounds  []float64
+    buckets []uint64
+    count   uint64
+}
+
+// metricHelp describes the metrics of Metrics.
+var metricHelp = map[string][2]string{
+    "dify_requests_total":              {"counter", "Dify calls made."},
+    "dify_request_duration_seconds":    {"histogram", "Time until a Dify call returns its response or starts its stream."},
+    "dify_time_to_first_token_seconds": {"histogram", "Time from sending a Dify call to its first generated text."},
+    "dify_stream_duration_seconds":     {"histogram", "Duration of Dify streams."},
+    "dify_tokens_total":                {"counter", "Tokens used by Dify calls."},
+    "dify_price_total":                 {"counter", "Price of Dify calls as reported in Usage."},
+}
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics() *Metrics {
+    return &Metrics{}
+}
+
+// Middleware returns a Middleware that records the metrics of each call.
+func (m *Metrics) Middleware() Middleware {
+    return func(next RoundTrip) RoundTrip {
+        return func(ctx context.Context, call *Call) (*http.Response, error) {
+            start := time.Now()
+            labels := [][2]string{{"endpoint", call.Endpoint}, {"app", call.App}}
+
+            opened := false
+            firstToken := false
+            call.OnEvent(func(event any) {
+                info := DescribeEvent(event)
+                if info.Text && !firstToken {
+                    firstToken = true
+                    m.observe("dify_time_to_first_token_seconds", labels, time.Since(start).Seconds())
+                }
+                if info.Usage != nil {
+                    m.recordUsage(labels, *info.Usage)
+                }
+            })
+            call.OnComplete(func(result any, err error) {
+                if result != nil {
+                    if info := DescribeEvent(result); info.Usage != nil {
+                        m.recordUsage(labels, *info.Usage)
+                    }
+                }
+                withOutcome := withLabel(labels, "outcome", outcome(err))
+                m.add("dify_requests_total", withOutcome, 1)
+                if opened {
+                    m.observe("dify_stream_duration_seconds", withOutcome, time.Since(start).Seconds())
+                } else {
+                    // Streams failing before they open count as requests.
+                    m.observe("dify_request_duration_seconds", withOutcome, time.Since(start).Seconds())
+                }
+            })
+
+            resp, err := next(ctx, call)
+            if call.Streaming && err == nil {
+                opened = true
+                m.observe("dify_request_duration_seconds", withLabel(labels, "outcome", "ok"), time.Since(start).Seconds())
+            }
+            return resp, err
+        }
+    }
+}
+
+// outcome classifies the error of a completed call.
+func outcome(err error) string {
+    switch {
+    case err == nil:
+        return "ok"
+    case errors.Is(err, context.Canceled):
+        return "canceled"
+    default:
+        return "error"
+    }
+}
+
+// recordUsage adds the tokens and price of a Usage.
+func (m *Metrics) recordUsage(labels [][2]string, usage Usage) {
+    tokens := map[string]int{"prompt": usage.PromptTokens, "completion": usage.CompletionTokens}
+    if usage.PromptTokens == 0 && usage.CompletionTokens == 0 {
+        // Workflows only report a total.
+        tokens = map[string]int{"total": usage.TotalTokens}
+    }
+    for tokenType, n := range tokens {
+        if n > 0 {
+            m.add("dify_tokens_total", withLabel(labels, "type", tokenType), float64(n))
+        }
+    }
+
+    if price, err := strconv.ParseFloat(usage.TotalPrice, 64); err == nil && price > 0 {
+        m.add("dify_price_total", withLabel(labels, "currency", usage.Currency), price)
+    }
+}
+
+// withLabel returns a copy of labels with one more label.
+func withLabel(labels [][2]string, name, value string) [][2]string {
+    return append(slices.Clip(labels), [2]string{name, value})
+}
+
+// add increases a counter.
+func (m *Metrics) add(name string, labels [][2]string, value float64) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.series(name, labels).value += value
+}
+
+// observe records a histogram observation.
+func (m *Metrics) observe(name string, labels [][2]string, value float64) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    s := m.series(name, labels)
+    for i, bound := range s.bounds {
+        if value <= bound {
+            s.buckets[i]++
+        }
+    }
+    s.count++
+    s.value += value
+}
+
+// series returns the series of a metric, creating it. m.mu must be held.
+func (m *Metrics) series(name string, labels [][2]string) *metricSeries {
+    if m.families == nil {
+        m.families = make(map[string]*metricFamily)
+    }
+    family, ok := m.families[name]
+    if !ok {
+        family = &metricFamily{
+            name:   name,
+            kind:   metricHelp[name][0],
+            help:   metricHelp[name][1],
+            series: make(map[string]*metricSeries),
+        }
+        m.families[name] = family
+    }
+
+    key := formatLabels(labels)
+    s, ok := family.series[key]
+    if !ok {
+        s = &metricSeries{}
+        if family.kind == "histogram" {
+            s.bounds = m.buckets()
+            s.buckets = make([]uint64, len(s.bounds))
+        }
+        family.series[key] = s
+    }
+    return s
+}
+
+// buckets returns a sorted copy of Buckets or its default, without
+// duplicates.
+func (m *Metrics) buckets() []float64 {
+    buckets := m.Buckets
+    if len(buckets) == 0 {
+        buckets = DefaultLatencyBuckets
+    }
+    return slices.Compact(slices.Sorted(slices.Values(buckets)))
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    bw := bufio.NewWriter(w)
+    cw := &countingWriter{w: bw}
+    names := make([]string, 0, len(m.families))
+    for name := range m.families {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    for _, name := range names {
+        family := m.families[name]
+        fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s %s\n", name, family.help, name, family.kind)
+
+        keys := make([]string, 0, len(family.series))
+        for key := range family.series {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+
+        for _, key := range keys {
+            s := family.series[key]
+            if family.kind != "histogram" {
+                fmt.Fprintf(cw, "%s%s %s\n", name, braces(key), formatFloat(s.value))
+                continue
+            }
+            for i, bound := range s.bounds {
+                fmt.Fprintf(cw, "%s_bucket%s %d\n", name, braces(joinLabels(key, `le="`+formatFloat(bound)+`"`)), s.buckets[i])
+            }
+            fmt.Fprintf(cw, "%s_bucket%s %d\n", name, braces(joinLabels(key, `le="+Inf"`)), s.count)
+            fmt.Fprintf(cw, "%s_sum%s %s\n", name, braces(key), formatFloat(s.value))
+            fmt.Fprintf(cw, "%s_count%s %d\n", name, braces(key), s.count)
+        }
+    }
+    if err := bw.Flush(); err != nil && cw.err == nil {
+        cw.err = err
+    }
+    return cw.n, cw.err
+}
+
+// ServeHTTP serves the metrics to a Prometheus scraper.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+    m.WriteTo(w)
+}
+
+// formatLabels renders label pairs, escaping their values.
+func formatLabels(labels [][2]string) string {
+    parts := make([]string, 0, len(labels))
+    for _, label := range labels {
+        value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(label[1])
+        parts = append(parts, label[0]+`="`+value+`"`)
+    }
+    return strings.Join(parts, ",")
+}
+
+// joinLabels appends a rendered label to rendered labels.
+func joinLabels(labels, label string) string {
+    if labels == "" {
+        return label
+    }
+    return labels + "," + label
+}
+
+// braces wraps rendered labels in braces, if any.
+func braces(labels string) string {
+    if labels == "" {
+        return ""
+    }
+    return "{" + labels + "}"
+}
+
+// formatFloat renders a sample value.
+func formatFloat(v float64) string {
+    return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// countingWriter counts bytes written and keeps the first error.
+type countingWriter struct {
+    w   io.Writer
+    n   int64
+    err error
+}
+
+// Write implements io.Writer.
+func (cw *countingWriter) Write(p []byte) (int, error) {
+    if cw.err != nil {
+        return 0, cw.err
+    }
+    n, err := cw.w.Write(p)
+    cw.n += int64(n)
+    cw.err = err
+    return n, err
+}
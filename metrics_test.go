@@ -0,0 +1,96 @@
+package dify_test
+
+import (
+    "context"
+    "net/http"
+    "regexp"
+    "slices"
+    "strings"
+    "testing"
+
+    dify "github.com/barlowliu/dify-go"
+    "github.com/barlowliu/dify-go/difytest"
+)
+
+func TestMetricsWriteTo(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    metrics := dify.NewMetrics()
+    metrics.Buckets = []float64{60, 30, 60}
+    client := srv.Client()
+    client.Use(metrics.Middleware())
+
+    srv.On(difytest.ChatMessages, pricedChat("0.5")(difytest.Request{}))
+    if err := sendChat(client, "alice"); err != nil {
+        t.Fatalf("blocking call: %v", err)
+    }
+    stream := func() error {
+        _, events, err := client.SendChatMessage(context.Background(), dify.ChatMessageRequest{Query: "hi", ResponseMode: "streaming", User: "alice"})
+        if err != nil {
+            return err
+        }
+        for range events {
+        }
+        return nil
+    }
+    if err := stream(); err != nil {
+        t.Fatalf("stream: %v", err)
+    }
+    srv.On(difytest.ChatMessages, difytest.Error(http.StatusInternalServerError, "internal_server_error", "boom"))
+    if err := stream(); err == nil {
+        t.Fatal("failing stream returned no error")
+    }
+
+    var out strings.Builder
+    n, err := metrics.WriteTo(&out)
+    if err != nil || n != int64(out.Len()) {
+        t.Fatalf("WriteTo = %d, %v for %d bytes", n, err, out.Len())
+    }
+    // Sums are durations, which vary.
+    got := regexp.MustCompile(`(?m)^(\w+_sum\{.*\}) .*$`).ReplaceAllString(out.String(), "$1 X")
+    want := `# HELP dify_price_total Price of Dify calls as reported in Usage.
+# TYPE dify_price_total counter
+dify_price_total{endpoint="SendChatMessage",app="",currency="USD"} 0.5
+# HELP dify_request_duration_seconds Time until a Dify call returns its response or starts its stream.
+# TYPE dify_request_duration_seconds histogram
+dify_request_duration_seconds_bucket{endpoint="SendChatMessage",app="",outcome="error",le="30"} 1
+dify_request_duration_seconds_bucket{endpoint="SendChatMessage",app="",outcome="error",le="60"} 1
+dify_request_duration_seconds_bucket{endpoint="SendChatMessage",app="",outcome="error",le="+Inf"} 1
+dify_request_duration_seconds_sum{endpoint="SendChatMessage",app="",outcome="error"} X
+dify_request_duration_seconds_count{endpoint="SendChatMessage",app="",outcome="error"} 1
+dify_request_duration_seconds_bucket{endpoint="SendChatMessage",app="",outcome="ok",le="30"} 2
+dify_request_duration_seconds_bucket{endpoint="SendChatMessage",app="",outcome="ok",le="60"} 2
+dify_request_duration_seconds_bucket{endpoint="SendChatMessage",app="",outcome="ok",le="+Inf"} 2
+dify_request_duration_seconds_sum{endpoint="SendChatMessage",app="",outcome="ok"} X
+dify_request_duration_seconds_count{endpoint="SendChatMessage",app="",outcome="ok"} 2
+# HELP dify_requests_total Dify calls made.
+# TYPE dify_requests_total counter
+dify_requests_total{endpoint="SendChatMessage",app="",outcome="error"} 1
+dify_requests_total{endpoint="SendChatMessage",app="",outcome="ok"} 2
+# HELP dify_stream_duration_seconds Duration of Dify streams.
+# TYPE dify_stream_duration_seconds histogram
+dify_stream_duration_seconds_bucket{endpoint="SendChatMessage",app="",outcome="ok",le="30"} 1
+dify_stream_duration_seconds_bucket{endpoint="SendChatMessage",app="",outcome="ok",le="60"} 1
+dify_stream_duration_seconds_bucket{endpoint="SendChatMessage",app="",outcome="ok",le="+Inf"} 1
+dify_stream_duration_seconds_sum{endpoint="SendChatMessage",app="",outcome="ok"} X
+dify_stream_duration_seconds_count{endpoint="SendChatMessage",app="",outcome="ok"} 1
+# HELP dify_time_to_first_token_seconds Time from sending a Dify call to its first generated text.
+# TYPE dify_time_to_first_token_seconds histogram
+dify_time_to_first_token_seconds_bucket{endpoint="SendChatMessage",app="",le="30"} 1
+dify_time_to_first_token_seconds_bucket{endpoint="SendChatMessage",app="",le="60"} 1
+dify_time_to_first_token_seconds_bucket{endpoint="SendChatMessage",app="",le="+Inf"} 1
+dify_time_to_first_token_seconds_sum{endpoint="SendChatMessage",app=""} X
+dify_time_to_first_token_seconds_count{endpoint="SendChatMessage",app=""} 1
+# HELP dify_tokens_total Tokens used by Dify calls.
+# TYPE dify_tokens_total counter
+dify_tokens_total{endpoint="SendChatMessage",app="",type="completion"} 1
+dify_tokens_total{endpoint="SendChatMessage",app="",type="prompt"} 1
+dify_tokens_total{endpoint="SendChatMessage",app="",type="total"} 10
+`
+    if got != want {
+        t.Errorf("WriteTo wrote\n%s\nwant\n%s", got, want)
+    }
+    if !slices.Equal(metrics.Buckets, []float64{60, 30, 60}) {
+        t.Errorf("Buckets changed to %v", metrics.Buckets)
+    }
+}
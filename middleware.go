@@ -0,0 +1,80 @@
+package dify
+
+import (
+    "context"
+    "net/http"
+    "net/url"
+)
+
+// Call describes a single API call passing through the middleware chain.
+type Call struct {
+    // Endpoint is the name of the Client method making the call,
+    // e.g. "SendChatMessage" or "RunWorkflow".
+    Endpoint string
+    Method   string
+    Path     string
+    Query    url.Values
+
+    // App and AppMode are the name and mode of the client's app, if set
+    // with WithApp.
+    App     string
+    AppMode AppMode
+
+    // Request is the decoded request body, e.g. a ChatMessageRequest.
+    // Middleware may replace it; it is encoded after the chain runs.
+    Request any
+
+    // Streaming reports whether the response is a server-sent event stream.
+    Streaming bool
+
+    // Header holds extra request headers. They are applied after the
+    // client's own headers, so middleware can override Authorization.
+    Header http.Header
+
+    eventHooks    []func(event any)
+    completeHooks []func(result any, err error)
+}
+
+// RoundTrip performs a call. It returns the response once a 2xx status is
+// received; any other status is returned as an *APIError. The response body
+// is read by the client after the chain returns.
+type RoundTrip func(ctx context.Context, call *Call) (*http.Response, error)
+
+// Middleware wraps a RoundTrip to observe or modify calls.
+type Middleware func(next RoundTrip) RoundTrip
+
+// Use appends middleware to the client. The first middleware registered is
+// the outermost. Use is not safe to call while requests are in flight.
+func (c *Client) Use(middleware ...Middleware) {
+    c.middlewares = append(c.middlewares, middleware...)
+}
+
+// OnEvent registers a hook that observes each decoded stream event, such as
+// a ChunkChatCompletionResponse. Hooks run on the stream's goroutine before
+// the event is delivered to the caller.
+func (call *Call) OnEvent(fn func(event any)) {
+    call.eventHooks = append(call.eventHooks, fn)
+}
+
+// OnComplete registers a hook that runs once when the call finishes.
+// For blocking calls result is the decoded response, e.g. a
+// *ChatCompletionResponse; for streaming calls it is nil and the hook runs
+// after the last event. Err is the call's error, a stream error event or
+// the error that ended the stream.
+func (call *Call) OnComplete(fn func(result any, err error)) {
+    call.completeHooks = append(call.completeHooks, fn)
+}
+
+// event runs the event hooks.
+func (call *Call) event(event any) {
+    for _, fn := range call.eventHooks {
+        fn(event)
+    }
+}
+
+// complete runs the completion hooks, innermost middleware first.
+func (call *Call) complete(result any, err error) {
+    for i := len(call.completeHooks) - 1; i >= 0; i-- {
+        call.completeHooks[i](result, err)
+    }
+}
@@ -1,8 +1,4 @@
-package dify-go
-
-import (
-    "time"
-)
+package dify
 
 // ChatMessageRequest represents the request body for sending chat messages.
 type ChatMessageRequest struct {
@@ -27,19 +23,34 @@ type ChatCompletionResponse struct {
 
 // ChunkChatCompletionResponse represents each chunk in streaming chat messages.
 type ChunkChatCompletionResponse struct {
-    Event          string                 `json:"event"`
-    TaskID         string                 `json:"task_id"`
-    MessageID      string                 `json:"message_id"`
-    ConversationID string                 `json:"conversation_id"`
-    Answer         string                 `json:"answer,omitempty"`
-    CreatedAt      int64                  `json:"created_at"`
+    Event          string                   `json:"event"`
+    TaskID         string                   `json:"task_id"`
+    MessageID      string                   `json:"message_id"`
+    ConversationID string                   `json:"conversation_id"`
+    Answer         string                   `json:"answer,omitempty"`
+    CreatedAt      int64                    `json:"created_at"`
     // Additional fields for different event types
-    Metadata       *Metadata              `json:"metadata,omitempty"`
-    Status         int                    `json:"status,omitempty"`
-    Code           string                 `json:"code,omitempty"`
-    Message        string                 `json:"message,omitempty"`
-    Audio          string                 `json:"audio,omitempty"`
+    Metadata       *Metadata                `json:"metadata,omitempty"`
+    Status         int                      `json:"status,omitempty"`
+    Code           string                   `json:"code,omitempty"`
+    Message        string                   `json:"message,omitempty"`
+    Audio          string                   `json:"audio,omitempty"`
+    // Fields of agent_thought and message_file events
+    ID             string                   `json:"id,omitempty"`
+    Position       int                      `json:"position,omitempty"`
+    Thought        string                   `json:"thought,omitempty"`
+    Observation    string                   `json:"observation,omitempty"`
+    Tool           string                   `json:"tool,omitempty"`
+    ToolLabels     map[string]LocalizedText `json:"tool_labels,omitempty"`
+    ToolInput      string                   `json:"tool_input,omitempty"`
+    MessageFiles   []string                 `json:"message_files,omitempty"`
+    Type           string                   `json:"type,omitempty"`
+    BelongsTo      string                   `json:"belongs_to,omitempty"`
+    URL            string                   `json:"url,omitempty"`
     // ... other fields as per API documentation
+
+    // err is the error that ended the stream while it was being read.
+    err error
 }
 
 // Metadata contains usage and retriever resources information.
@@ -102,9 +113,10 @@ type CompletionMessageRequest struct {
 
 // CompletionResponse represents the response for blocking completion messages.
 type CompletionResponse struct {
-    ID        string `json:"id"`
-    Answer    string `json:"answer"`
-    CreatedAt int64  `json:"created_at"`
+    ID        string   `json:"id"`
+    Answer    string   `json:"answer"`
+    Metadata  Metadata `json:"metadata"`
+    CreatedAt int64    `json:"created_at"`
 }
 
 // FileUploadResponse represents the response after uploading a file.
@@ -144,6 +156,55 @@ type WorkflowRunData struct {
     FinishedAt    int64   `json:"finished_at,omitempty"`
 }
 
+// ChunkCompletionResponse represents each chunk in streaming workflow runs.
+type ChunkCompletionResponse struct {
+    Event         string              `json:"event"`
+    TaskID        string              `json:"task_id"`
+    WorkflowRunID string              `json:"workflow_run_id"`
+    Data          *WorkflowStreamData `json:"data,omitempty"`
+    // Additional fields for different event types
+    Status  int    `json:"status,omitempty"`
+    Code    string `json:"code,omitempty"`
+    Message string `json:"message,omitempty"`
+    Audio   string `json:"audio,omitempty"`
+
+    // err is the error that ended the stream while it was being read.
+    err error
+}
+
+// WorkflowStreamData holds the data of a workflow stream event.
+// Which fields are set depends on the event type.
+type WorkflowStreamData struct {
+    ID                   string                 `json:"id,omitempty"`
+    WorkflowID           string                 `json:"workflow_id,omitempty"`
+    SequenceNumber       int                    `json:"sequence_number,omitempty"`
+    NodeID               string                 `json:"node_id,omitempty"`
+    NodeType             string                 `json:"node_type,omitempty"`
+    Title                string                 `json:"title,omitempty"`
+    Index                int                    `json:"index,omitempty"`
+    PredecessorNodeID    string                 `json:"predecessor_node_id,omitempty"`
+    Inputs               map[string]interface{} `json:"inputs,omitempty"`
+    ProcessData          map[string]interface{} `json:"process_data,omitempty"`
+    Outputs              map[string]interface{} `json:"outputs,omitempty"`
+    Status               string                 `json:"status,omitempty"`
+    Error                string                 `json:"error,omitempty"`
+    ElapsedTime          float64                `json:"elapsed_time,omitempty"`
+    ExecutionMetadata    *ExecutionMetadata     `json:"execution_metadata,omitempty"`
+    TotalTokens          int                    `json:"total_tokens,omitempty"`
+    TotalSteps           int                    `json:"total_steps,omitempty"`
+    CreatedAt            int64                  `json:"created_at,omitempty"`
+    FinishedAt           int64                  `json:"finished_at,omitempty"`
+    Text                 string                 `json:"text,omitempty"`
+    FromVariableSelector []string               `json:"from_variable_selector,omitempty"`
+}
+
+// ExecutionMetadata contains the token usage of a workflow node.
+type ExecutionMetadata struct {
+    TotalTokens int         `json:"total_tokens,omitempty"`
+    TotalPrice  interface{} `json:"total_price,omitempty"`
+    Currency    string      `json:"currency,omitempty"`
+}
+
 // WorkflowStatusResponse represents the response for getting workflow status.
 type WorkflowStatusResponse struct {
     ID          string  `json:"id"`
@@ -159,3 +220,239 @@ type WorkflowStatusResponse struct {
     ElapsedTime float64 `json:"elapsed_time"`
 }
 
+// Metadata field types supported by knowledge datasets.
+const (
+    MetadataTypeString = "string"
+    MetadataTypeNumber = "number"
+    MetadataTypeTime   = "time"
+)
+
+// DatasetMetadataRequest represents the request body for creating a metadata field.
+type DatasetMetadataRequest struct {
+    Type string `json:"type"`
+    Name string `json:"name"`
+}
+
+// DatasetMetadata represents a custom metadata field of a dataset.
+type DatasetMetadata struct {
+    ID       string `json:"id"`
+    Type     string `json:"type"`
+    Name     string `json:"name"`
+    UseCount int    `json:"use_count,omitempty"`
+}
+
+// DatasetMetadataListResponse represents the response for listing dataset metadata fields.
+type DatasetMetadataListResponse struct {
+    DocMetadata         []DatasetMetadata `json:"doc_metadata"`
+    BuiltInFieldEnabled bool              `json:"built_in_field_enabled"`
+}
+
+// BuiltInMetadataField represents a built-in metadata field provided by Dify.
+type BuiltInMetadataField struct {
+    Name string `json:"name"`
+    Type string `json:"type"`
+}
+
+// BuiltInMetadataFieldsResponse represents the response for listing built-in metadata fields.
+type BuiltInMetadataFieldsResponse struct {
+    Fields []BuiltInMetadataField `json:"fields"`
+}
+
+// DocumentMetadataUpdateRequest represents the request body for bulk-updating document metadata.
+type DocumentMetadataUpdateRequest struct {
+    OperationData []DocumentMetadataOperation `json:"operation_data"`
+}
+
+// DocumentMetadataOperation sets the metadata values of a single document.
+type DocumentMetadataOperation struct {
+    DocumentID   string                  `json:"document_id"`
+    MetadataList []DocumentMetadataValue `json:"metadata_list"`
+}
+
+// DocumentMetadataValue represents the value of one metadata field on a document.
+// Value holds a string, a number or a unix timestamp depending on the field type.
+type DocumentMetadataValue struct {
+    ID    string      `json:"id"`
+    Name  string      `json:"name"`
+    Value interface{} `json:"value"`
+}
+
+// KnowledgeTag represents a tag that can be bound to datasets.
+type KnowledgeTag struct {
+    ID           string `json:"id"`
+    Name         string `json:"name"`
+    Type         string `json:"type,omitempty"`
+    BindingCount int    `json:"binding_count,omitempty"`
+}
+
+// DatasetTagsResponse represents the response for listing the tags of a dataset.
+type DatasetTagsResponse struct {
+    Data  []KnowledgeTag `json:"data"`
+    Total int            `json:"total"`
+}
+
+// LocalizedText holds a label in the languages returned by Dify.
+type LocalizedText struct {
+    EnUS   string `json:"en_US"`
+    ZhHans string `json:"zh_Hans,omitempty"`
+}
+
+// EmbeddingModelsResponse represents the response for listing text embedding models.
+type EmbeddingModelsResponse struct {
+    Data []EmbeddingProvider `json:"data"`
+}
+
+// EmbeddingProvider represents a model provider and the embedding models it offers.
+type EmbeddingProvider struct {
+    Provider  string           `json:"provider"`
+    Label     LocalizedText    `json:"label"`
+    IconSmall *LocalizedText   `json:"icon_small,omitempty"`
+    IconLarge *LocalizedText   `json:"icon_large,omitempty"`
+    Status    string           `json:"status"`
+    Models    []EmbeddingModel `json:"models"`
+}
+
+// EmbeddingModel represents a single text embedding model of a provider.
+type EmbeddingModel struct {
+    Model                string                 `json:"model"`
+    Label                LocalizedText          `json:"label"`
+    ModelType            string                 `json:"model_type"`
+    Features             []string               `json:"features"`
+    FetchFrom            string                 `json:"fetch_from"`
+    ModelProperties      map[string]interface{} `json:"model_properties"`
+    Deprecated           bool                   `json:"deprecated"`
+    Status               string                 `json:"status"`
+    LoadBalancingEnabled bool                   `json:"load_balancing_enabled"`
+}
+
+// EmbeddingModelChoice is a provider/model pair usable when creating a dataset.
+type EmbeddingModelChoice struct {
+    Provider string
+    Model    string
+}
+
+// DocumentByFileRequest represents the settings sent alongside a document file.
+type DocumentByFileRequest struct {
+    // FileName is the name the document gets in the dataset.
+    // It defaults to the base name of the uploaded file.
+    FileName          string       `json:"-"`
+    IndexingTechnique string       `json:"indexing_technique,omitempty"`
+    DocForm           string       `json:"doc_form,omitempty"`
+    DocLanguage       string       `json:"doc_language,omitempty"`
+    ProcessRule       *ProcessRule `json:"process_rule,omitempty"`
+}
+
+// ProcessRule describes how Dify cleans and segments a document.
+type ProcessRule struct {
+    Mode  string                 `json:"mode"`
+    Rules map[string]interface{} `json:"rules,omitempty"`
+}
+
+// Document represents a document of a knowledge dataset.
+type Document struct {
+    ID             string  `json:"id"`
+    Position       int     `json:"position"`
+    DataSourceType string  `json:"data_source_type"`
+    Name           string  `json:"name"`
+    CreatedFrom    string  `json:"created_from"`
+    CreatedBy      string  `json:"created_by"`
+    CreatedAt      int64   `json:"created_at"`
+    Tokens         int     `json:"tokens"`
+    IndexingStatus string  `json:"indexing_status"`
+    Error          *string `json:"error,omitempty"`
+    Enabled        bool    `json:"enabled"`
+    DisplayStatus  string  `json:"display_status"`
+    WordCount      int     `json:"word_count"`
+}
+
+// DocumentResponse represents the response after creating or updating a document.
+type DocumentResponse struct {
+    Document Document `json:"document"`
+    Batch    string   `json:"batch"`
+}
+
+// AnnotationRequest represents the request body for creating or updating an annotation.
+type AnnotationRequest struct {
+    Question string `json:"question"`
+    Answer   string `json:"answer"`
+}
+
+// Annotation represents a curated question/answer pair used for annotation reply.
+type Annotation struct {
+    ID        string `json:"id"`
+    Question  string `json:"question"`
+    Answer    string `json:"answer"`
+    HitCount  int    `json:"hit_count"`
+    CreatedAt int64  `json:"created_at"`
+}
+
+// AnnotationListResponse represents a page of annotations.
+type AnnotationListResponse struct {
+    Data    []Annotation `json:"data"`
+    HasMore bool         `json:"has_more"`
+    Limit   int          `json:"limit"`
+    Total   int          `json:"total"`
+    Page    int          `json:"page"`
+}
+
+// AnnotationReplySettings represents the request body for enabling annotation reply.
+type AnnotationReplySettings struct {
+    EmbeddingProviderName string  `json:"embedding_provider_name"`
+    EmbeddingModelName    string  `json:"embedding_model_name"`
+    ScoreThreshold        float64 `json:"score_threshold"`
+}
+
+// AnnotationReplyJob represents the state of an asynchronous annotation reply job.
+type AnnotationReplyJob struct {
+    JobID     string `json:"job_id"`
+    JobStatus string `json:"job_status"`
+    ErrorMsg  string `json:"error_msg,omitempty"`
+}
+
+// AgentThought represents one reasoning step of an Agent app, assembled from
+// agent_thought events and the message_file events it produced.
+type AgentThought struct {
+    ID             string
+    MessageID      string
+    ConversationID string
+    Position       int
+    Thought        string
+    Observation    string
+    Tool           string
+    ToolLabels     map[string]LocalizedText
+    // ToolInput is the parsed tool_input. When several tools are used in
+    // one step it is keyed by tool name. It is nil if tool_input is not a
+    // JSON object; RawToolInput always holds the original string.
+    ToolInput    map[string]interface{}
+    RawToolInput string
+    MessageFiles []string
+    Files        []MessageFile
+    CreatedAt    int64
+}
+
+// MessageFile represents a file produced during a message, such as a generated image.
+type MessageFile struct {
+    ID             string
+    Type           string
+    BelongsTo      string
+    URL            string
+    ConversationID string
+}
+
+// ConversationVariable represents a variable stored with a chatflow conversation.
+type ConversationVariable struct {
+    ID          string      `json:"id"`
+    Name        string      `json:"name"`
+    ValueType   string      `json:"value_type"`
+    Value       interface{} `json:"value"`
+    Description string      `json:"description"`
+    CreatedAt   int64       `json:"created_at"`
+    UpdatedAt   int64       `json:"updated_at"`
+}
+
+// ConversationVariablesResponse represents a page of conversation variables.
+type ConversationVariablesResponse struct {
+    Limit   int                    `json:"limit"`
+    HasMore bool                   `json:"has_more"`
+    Data    []ConversationVariable `json:"data"`
+}
@@ -0,0 +1,332 @@
+package dify
+
+import (
+    "crypto/tls"
+    "log/slog"
+    "net"
+    "net/http"
+    "net/url"
+    "time"
+)
+
+// Option configures a Client created by NewClient.
+type Option func(*clientConfig)
+
+// clientConfig collects the options before NewClient assembles the client.
+type clientConfig struct {
+    httpClient   *http.Client
+    transport    http.RoundTripper
+    proxy        func(*http.Request) (*url.URL, error)
+    tlsConfig    *tls.Config
+    certificates []tls.Certificate
+    retry        RetryPolicy
+    userAgent    string
+    defaultUser  string
+    appName      string
+    appMode      AppMode
+    headers      http.Header
+    logger       *slog.Logger
+    redact       Redact
+    keys         *KeyPool
+    limits       map[string]*limitScope
+    hedge        *HedgePolicy
+
+    connectTimeout        time.Duration
+    tlsHandshakeTimeout   time.Duration
+    responseHeaderTimeout time.Duration
+    idleConnTimeout       time.Duration
+}
+
+// WithHTTPClient uses a copy of the given http.Client for requests.
+// Transport options still apply when its transport is an *http.Transport.
+func WithHTTPClient(client *http.Client) Option {
+    return func(cfg *clientConfig) {
+        cfg.httpClient = client
+    }
+}
+
+// WithTransport sets the RoundTripper used for requests. Proxy, TLS and
+// timeout options only apply when it is an *http.Transport.
+func WithTransport(transport http.RoundTripper) Option {
+    return func(cfg *clientConfig) {
+        cfg.transport = transport
+    }
+}
+
+// WithProxy sends requests through the given proxy.
+func WithProxy(proxyURL *url.URL) Option {
+    return func(cfg *clientConfig) {
+        cfg.proxy = http.ProxyURL(proxyURL)
+    }
+}
+
+// WithTLSConfig sets the TLS configuration, e.g. to trust a private CA.
+func WithTLSConfig(config *tls.Config) Option {
+    return func(cfg *clientConfig) {
+        cfg.tlsConfig = config
+    }
+}
+
+// WithClientCertificate presents a client certificate for mutual TLS.
+func WithClientCertificate(cert tls.Certificate) Option {
+    return func(cfg *clientConfig) {
+        cfg.certificates = append(cfg.certificates, cert)
+    }
+}
+
+// WithRetryPolicy replaces DefaultRetryPolicy.
+func WithRetryPolicy(policy RetryPolicy) Option {
+    return func(cfg *clientConfig) {
+        cfg.retry = policy
+    }
+}
+
+// WithUserAgent sets the User-Agent header of every request.
+func WithUserAgent(userAgent string) Option {
+    return func(cfg *clientConfig) {
+        cfg.userAgent = userAgent
+    }
+}
+
+// WithDefaultUser sets the user identifier sent when a request leaves it empty.
+func WithDefaultUser(user string) Option {
+    return func(cfg *clientConfig) {
+        cfg.defaultUser = user
+    }
+}
+
+// WithApp names the app the client's API key belongs to. The name labels
+// calls in middleware; when mode is set, calls to endpoints the mode does
+// not serve fail with ErrAppModeMismatch.
+func WithApp(name string, mode AppMode) Option {
+    return func(cfg *clientConfig) {
+        cfg.appName = name
+        cfg.appMode = mode
+    }
+}
+
+// WithHeader adds a header to every request. It can be given several times.
+func WithHeader(key, value string) Option {
+    return func(cfg *clientConfig) {
+        if cfg.headers == nil {
+            cfg.headers = make(http.Header)
+        }
+        cfg.headers.Add(key, value)
+    }
+}
+
+// WithLogger logs the lifecycle of calls, retries, stream event counts and
+// errors. Completed calls are logged at Info level, retries and client
+// errors at Warn and server or transport errors at Error. Request details
+// and headers are logged at Debug level, with the API key masked.
+func WithLogger(logger *slog.Logger) Option {
+    return func(cfg *clientConfig) {
+        cfg.logger = logger
+    }
+}
+
+// WithLogRedaction masks request contents in logs, e.g.
+// WithLogRedaction(RedactQuery|RedactInputs).
+func WithLogRedaction(redact Redact) Option {
+    return func(cfg *clientConfig) {
+        cfg.redact = redact
+    }
+}
+
+// WithKeyPool sends calls with the keys of pool instead of the apiKey given
+// to NewClient, which may then be empty.
+func WithKeyPool(pool *KeyPool) Option {
+    return func(cfg *clientConfig) {
+        cfg.keys = pool
+    }
+}
+
+// WithRateLimiter paces all calls of the client. Pass the same limiter to
+// several clients to share a budget, e.g. per API key.
+func WithRateLimiter(limiter RateLimiter) Option {
+    return func(cfg *clientConfig) {
+        cfg.limitScope("").rate = limiter
+    }
+}
+
+// WithEndpointRateLimiter paces the calls of one endpoint, named after the
+// Client method, e.g. "SendChatMessage". It applies in addition to the
+// client-wide limiter.
+func WithEndpointRateLimiter(endpoint string, limiter RateLimiter) Option {
+    return func(cfg *clientConfig) {
+        cfg.limitScope(endpoint).rate = limiter
+    }
+}
+
+// WithConcurrencyLimit bounds the number of calls in flight. A streaming
+// call counts until its stream ends, so callers must read its channel until
+// it is closed or cancel the call's context; a stream abandoned otherwise
+// holds its slot forever. It panics if n is not positive.
+func WithConcurrencyLimit(n int) Option {
+    if n <= 0 {
+        panic("dify: non-positive WithConcurrencyLimit")
+    }
+    return func(cfg *clientConfig) {
+        cfg.limitScope("").slots = make(chan struct{}, n)
+    }
+}
+
+// WithEndpointConcurrencyLimit bounds the number of calls in flight for one
+// endpoint, e.g. "SendChatMessage". It applies in addition to the
+// client-wide limit. It panics if n is not positive.
+func WithEndpointConcurrencyLimit(endpoint string, n int) Option {
+    if n <= 0 {
+        panic("dify: non-positive WithEndpointConcurrencyLimit")
+    }
+    return func(cfg *clientConfig) {
+        cfg.limitScope(endpoint).slots = make(chan struct{}, n)
+    }
+}
+
+// WithHedging hedges blocking SendCompletionMessage calls according to
+// policy. Hedged calls are sent in streaming mode and assembled into the
+// blocking response.
+func WithHedging(policy HedgePolicy) Option {
+    return func(cfg *clientConfig) {
+        cfg.hedge = &policy
+    }
+}
+
+// WithConnectTimeout bounds establishing the TCP connection.
+func WithConnectTimeout(timeout time.Duration) Option {
+    return func(cfg *clientConfig) {
+        cfg.connectTimeout = timeout
+    }
+}
+
+// WithTLSHandshakeTimeout bounds the TLS handshake.
+func WithTLSHandshakeTimeout(timeout time.Duration) Option {
+    return func(cfg *clientConfig) {
+        cfg.tlsHandshakeTimeout = timeout
+    }
+}
+
+// WithResponseHeaderTimeout bounds the wait for response headers after the
+// request is written. Unlike SetTimeout it does not limit how long a
+// streaming response may last.
+func WithResponseHeaderTimeout(timeout time.Duration) Option {
+    return func(cfg *clientConfig) {
+        cfg.responseHeaderTimeout = timeout
+    }
+}
+
+// WithIdleConnTimeout sets how long idle keep-alive connections are kept.
+func WithIdleConnTimeout(timeout time.Duration) Option {
+    return func(cfg *clientConfig) {
+        cfg.idleConnTimeout = timeout
+    }
+}
+
+// buildHTTPClient assembles the http.Client from the transport options.
+func (cfg *clientConfig) buildHTTPClient() *http.Client {
+    httpClient := &http.Client{}
+    if cfg.httpClient != nil {
+        *httpClient = *cfg.httpClient
+    }
+    if cfg.transport != nil {
+        httpClient.Transport = cfg.transport
+    }
+    if httpClient.Transport == nil {
+        httpClient.Transport = http.DefaultTransport
+    }
+
+    transport, ok := httpClient.Transport.(*http.Transport)
+    if !ok || !cfg.customizesTransport() {
+        return httpClient
+    }
+
+    transport = transport.Clone()
+    if cfg.proxy != nil {
+        transport.Proxy = cfg.proxy
+    }
+    if cfg.tlsConfig != nil || len(cfg.certificates) > 0 {
+        tlsConfig := &tls.Config{}
+        if cfg.tlsConfig != nil {
+            tlsConfig = cfg.tlsConfig.Clone()
+        }
+        tlsConfig.Certificates = append(tlsConfig.Certificates, cfg.certificates...)
+        transport.TLSClientConfig = tlsConfig
+    }
+    if cfg.connectTimeout > 0 {
+        transport.DialContext = (&net.Dialer{
+            Timeout:   cfg.connectTimeout,
+            KeepAlive: 30 * time.Second,
+        }).DialContext
+    }
+    if cfg.tlsHandshakeTimeout > 0 {
+        transport.TLSHandshakeTimeout = cfg.tlsHandshakeTimeout
+    }
+    if cfg.responseHeaderTimeout > 0 {
+        transport.ResponseHeaderTimeout = cfg.responseHeaderTimeout
+    }
+    if cfg.idleConnTimeout > 0 {
+        transport.IdleConnTimeout = cfg.idleConnTimeout
+    }
+    httpClient.Transport = transport
+    return httpClient
+}
+
+// customizesTransport reports whether any option modifies the transport.
+func (cfg *clientConfig) customizesTransport() bool {
+    return cfg.proxy != nil || cfg.tlsConfig != nil || len(cfg.certificates) > 0 ||
+        cfg.connectTimeout > 0 || cfg.tlsHandshakeTimeout > 0 ||
+        cfg.responseHeaderTimeout > 0 || cfg.idleConnTimeout > 0
+}
+
+// limitScope returns the limiters of an endpoint, or of the whole client
+// for the empty name, creating them on first use.
+func (cfg *clientConfig) limitScope(name string) *limitScope {
+    if cfg.limits == nil {
+        cfg.limits = make(map[string]*limitScope)
+    }
+    scope, ok := cfg.limits[name]
+    if !ok {
+        scope = &limitScope{}
+        cfg.limits[name] = scope
+    }
+    return scope
+}
+
+// applyDefaultUser fills in the default user where a request leaves it empty.
+func (c *Client) applyDefaultUser(call *Call) {
+    if c.defaultUser == "" {
+        return
+    }
+    switch req := call.Request.(type) {
+    case ChatMessageRequest:
+        if req.User == "" {
+            req.User = c.defaultUser
+            call.Request = req
+        }
+    case CompletionMessageRequest:
+        if req.User == "" {
+            req.User = c.defaultUser
+            call.Request = req
+        }
+    case WorkflowRunRequest:
+        if req.User == "" {
+            req.User = c.defaultUser
+            call.Request = req
+        }
+    case map[string]string:
+        if user, ok := req["user"]; ok && user == "" {
+            req["user"] = c.defaultUser
+        }
+    case map[string]interface{}:
+        if user, ok := req["user"]; ok && user == "" {
+            req["user"] = c.defaultUser
+        }
+    case multipartFile:
+        if user, ok := req.fields["user"]; ok && user == "" {
+            req.fields["user"] = c.defaultUser
+        }
+    }
+    if user, ok := call.Query["user"]; ok && len(user) == 1 && user[0] == "" {
+        call.Query.Set("user", c.defaultUser)
+    }
+}
@@ -0,0 +1,309 @@
+// Package otel instruments a dify client with OpenTelemetry.
+//
+// Install the middleware on a client:
+//
+//    mw, err := otel.Middleware()
+//    if err != nil {
+//        return err
+//    }
+//    client.Use(mw)
+//
+// Every call gets a client span. Workflow runs get a child span per node,
+// built from node_started and node_finished events. Latency, time to first
+// token, token usage and errors are recorded as metrics, and the W3C trace
+// context is sent in the request headers.
+package otel
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "slices"
+    "time"
+
+    dify "github.com/barlowliu/dify-go"
+    otelapi "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/metric"
+    "go.opentelemetry.io/otel/propagation"
+    "go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the tracer and meter.
+const instrumentationName = "github.com/barlowliu/dify-go/otel"
+
+// Option configures the middleware.
+type Option func(*config)
+
+// config holds the providers used by the middleware.
+type config struct {
+    tracerProvider trace.TracerProvider
+    meterProvider  metric.MeterProvider
+    propagator     propagation.TextMapPropagator
+}
+
+// WithTracerProvider sets the TracerProvider. Defaults to the global one.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+    return func(cfg *config) {
+        cfg.tracerProvider = provider
+    }
+}
+
+// WithMeterProvider sets the MeterProvider. Defaults to the global one.
+func WithMeterProvider(provider metric.MeterProvider) Option {
+    return func(cfg *config) {
+        cfg.meterProvider = provider
+    }
+}
+
+// WithPropagator sets the propagator that writes trace context into request
+// headers. Defaults to the W3C trace context, since the global propagator
+// is a no-op until an application sets one.
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+    return func(cfg *config) {
+        cfg.propagator = propagator
+    }
+}
+
+// instruments are the metrics recorded by the middleware.
+type instruments struct {
+    duration         metric.Float64Histogram
+    timeToFirstToken metric.Float64Histogram
+    tokens           metric.Int64Counter
+    errors           metric.Int64Counter
+}
+
+// Middleware returns a dify.Middleware that traces calls and records metrics.
+func Middleware(opts ...Option) (dify.Middleware, error) {
+    cfg := &config{
+        tracerProvider: otelapi.GetTracerProvider(),
+        meterProvider:  otelapi.GetMeterProvider(),
+        propagator:     propagation.TraceContext{},
+    }
+    for _, opt := range opts {
+        opt(cfg)
+    }
+
+    tracer := cfg.tracerProvider.Tracer(instrumentationName)
+    meter := cfg.meterProvider.Meter(instrumentationName)
+
+    var inst instruments
+    var err, errs error
+    inst.duration, err = meter.Float64Histogram("dify.client.duration",
+        metric.WithUnit("s"), metric.WithDescription("Duration of Dify calls, including streams."))
+    errs = errors.Join(errs, err)
+    inst.timeToFirstToken, err = meter.Float64Histogram("dify.client.time_to_first_token",
+        metric.WithUnit("s"), metric.WithDescription("Time from sending a call to its first generated text."))
+    errs = errors.Join(errs, err)
+    inst.tokens, err = meter.Int64Counter("dify.client.tokens",
+        metric.WithUnit("{token}"), metric.WithDescription("Tokens consumed, by type."))
+    errs = errors.Join(errs, err)
+    inst.errors, err = meter.Int64Counter("dify.client.errors",
+        metric.WithUnit("{error}"), metric.WithDescription("Failed Dify calls, by error type."))
+    errs = errors.Join(errs, err)
+    if errs != nil {
+        return nil, errs
+    }
+
+    return func(next dify.RoundTrip) dify.RoundTrip {
+        return func(ctx context.Context, call *dify.Call) (*http.Response, error) {
+            t := &tracedCall{
+                tracer: tracer,
+                inst:   &inst,
+                start:  time.Now(),
+                attrs:  callAttributes(call),
+                nodes:  make(map[string]trace.Span),
+
+                conversationID: call.ConversationID(),
+            }
+            t.ctx, t.span = tracer.Start(ctx, "dify."+call.Endpoint,
+                trace.WithSpanKind(trace.SpanKindClient),
+                trace.WithAttributes(t.attrs...),
+                trace.WithAttributes(spanAttributes(call)...))
+            cfg.propagator.Inject(t.ctx, propagation.HeaderCarrier(call.Header))
+
+            call.OnEvent(t.event)
+            call.OnComplete(t.complete)
+
+            resp, err := next(t.ctx, call)
+            if resp != nil {
+                t.span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+            }
+            return resp, err
+        }
+    }, nil
+}
+
+// tracedCall is the tracing state of one call.
+type tracedCall struct {
+    tracer trace.Tracer
+    inst   *instruments
+    ctx    context.Context
+    span   trace.Span
+    start  time.Time
+    attrs  []attribute.KeyValue
+
+    firstToken     bool
+    taskID         string
+    conversationID string
+    workflowRunID  string
+    nodes          map[string]trace.Span
+}
+
+// event handles a stream event.
+func (t *tracedCall) event(event any) {
+    info := dify.DescribeEvent(event)
+    t.setIDs(info)
+    if info.Text && !t.firstToken {
+        t.firstToken = true
+        t.span.AddEvent("first_token")
+        t.inst.timeToFirstToken.Record(t.ctx, time.Since(t.start).Seconds(), metric.WithAttributes(t.attrs...))
+    }
+    if info.Usage != nil {
+        t.recordUsage(*info.Usage)
+    }
+
+    chunk, ok := event.(dify.ChunkCompletionResponse)
+    if !ok || chunk.Data == nil {
+        return
+    }
+    switch chunk.Event {
+    case "node_started":
+        _, span := t.tracer.Start(t.ctx, "dify.node "+chunk.Data.Title,
+            trace.WithSpanKind(trace.SpanKindInternal),
+            trace.WithAttributes(
+                attribute.String("dify.node.id", chunk.Data.NodeID),
+                attribute.String("dify.node.type", chunk.Data.NodeType),
+                attribute.String("dify.node.title", chunk.Data.Title),
+                attribute.Int("dify.node.index", chunk.Data.Index),
+            ))
+        t.nodes[chunk.Data.ID] = span
+    case "node_finished":
+        span, ok := t.nodes[chunk.Data.ID]
+        if !ok {
+            return
+        }
+        delete(t.nodes, chunk.Data.ID)
+        span.SetAttributes(
+            attribute.String("dify.node.status", chunk.Data.Status),
+            attribute.Float64("dify.node.elapsed_time", chunk.Data.ElapsedTime),
+        )
+        if meta := chunk.Data.ExecutionMetadata; meta != nil && meta.TotalTokens > 0 {
+            span.SetAttributes(attribute.Int("dify.node.total_tokens", meta.TotalTokens))
+        }
+        if chunk.Data.Status == "failed" {
+            span.SetStatus(codes.Error, chunk.Data.Error)
+        }
+        span.End()
+    }
+}
+
+// complete ends the call span and records its metrics.
+func (t *tracedCall) complete(result any, err error) {
+    if result != nil {
+        info := dify.DescribeEvent(result)
+        t.setIDs(info)
+        if info.Usage != nil {
+            t.recordUsage(*info.Usage)
+        }
+    }
+
+    for id, span := range t.nodes {
+        span.End()
+        delete(t.nodes, id)
+    }
+
+    outcome := "ok"
+    if err != nil {
+        outcome = "error"
+        var apiErr *dify.APIError
+        errorType := "transport"
+        if errors.As(err, &apiErr) {
+            errorType = apiErr.Code
+            if errorType == "" {
+                errorType = http.StatusText(apiErr.StatusCode)
+            }
+            if apiErr.StatusCode != 0 {
+                t.span.SetAttributes(attribute.Int("http.response.status_code", apiErr.StatusCode))
+            }
+        } else if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+            errorType = "canceled"
+        }
+        t.span.RecordError(err)
+        t.span.SetStatus(codes.Error, err.Error())
+        t.inst.errors.Add(t.ctx, 1, withAttribute(t.attrs, attribute.String("error.type", errorType)))
+    }
+
+    t.inst.duration.Record(t.ctx, time.Since(t.start).Seconds(),
+        withAttribute(t.attrs, attribute.String("dify.outcome", outcome)))
+    t.span.End()
+}
+
+// setIDs adds the IDs an event reveals to the call span, once each.
+func (t *tracedCall) setIDs(info dify.EventInfo) {
+    if info.TaskID != "" && t.taskID == "" {
+        t.taskID = info.TaskID
+        t.span.SetAttributes(attribute.String("dify.task_id", info.TaskID))
+    }
+    if info.ConversationID != "" && t.conversationID == "" {
+        t.conversationID = info.ConversationID
+        t.span.SetAttributes(attribute.String("dify.conversation_id", info.ConversationID))
+    }
+    if info.WorkflowRunID != "" && t.workflowRunID == "" {
+        t.workflowRunID = info.WorkflowRunID
+        t.span.SetAttributes(attribute.String("dify.workflow_run_id", info.WorkflowRunID))
+    }
+}
+
+// recordUsage records token usage on the span and the token counter.
+func (t *tracedCall) recordUsage(usage dify.Usage) {
+    t.span.SetAttributes(
+        attribute.Int("dify.usage.prompt_tokens", usage.PromptTokens),
+        attribute.Int("dify.usage.completion_tokens", usage.CompletionTokens),
+        attribute.Int("dify.usage.total_tokens", usage.TotalTokens),
+    )
+    counts := map[string]int{"prompt": usage.PromptTokens, "completion": usage.CompletionTokens}
+    if usage.PromptTokens == 0 && usage.CompletionTokens == 0 {
+        // Workflows only report a total.
+        counts = map[string]int{"total": usage.TotalTokens}
+    }
+    for tokenType, n := range counts {
+        if n > 0 {
+            t.inst.tokens.Add(t.ctx, int64(n), withAttribute(t.attrs, attribute.String("dify.token.type", tokenType)))
+        }
+    }
+}
+
+// callAttributes are the low-cardinality attributes shared by spans and
+// metrics.
+func callAttributes(call *dify.Call) []attribute.KeyValue {
+    attrs := []attribute.KeyValue{attribute.String("dify.endpoint", call.Endpoint)}
+    if call.App != "" {
+        attrs = append(attrs, attribute.String("dify.app", call.App))
+    }
+    if call.AppMode != "" {
+        attrs = append(attrs, attribute.String("dify.app_mode", string(call.AppMode)))
+    }
+    return attrs
+}
+
+// withAttribute returns the measurement option for attrs plus extra.
+func withAttribute(attrs []attribute.KeyValue, extra attribute.KeyValue) metric.MeasurementOption {
+    return metric.WithAttributes(append(slices.Clip(attrs), extra)...)
+}
+
+// spanAttributes are the attributes only set on the call span.
+func spanAttributes(call *dify.Call) []attribute.KeyValue {
+    attrs := []attribute.KeyValue{
+        attribute.String("http.request.method", call.Method),
+        attribute.Bool("dify.streaming", call.Streaming),
+    }
+    if user := call.User(); user != "" {
+        attrs = append(attrs, attribute.String("enduser.id", user))
+    }
+    if conversationID := call.ConversationID(); conversationID != "" {
+        attrs = append(attrs, attribute.String("dify.conversation_id", conversationID))
+    }
+    return attrs
+}
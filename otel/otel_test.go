@@ -0,0 +1,136 @@
+package otel_test
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "testing"
+
+    dify "github.com/barlowliu/dify-go"
+    "github.com/barlowliu/dify-go/difytest"
+    "github.com/barlowliu/dify-go/otel"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
+    sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+    "go.opentelemetry.io/otel/sdk/metric/metricdata"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    "go.opentelemetry.io/otel/sdk/trace/tracetest"
+    "go.opentelemetry.io/otel/trace"
+)
+
+// newTracedClient returns a client of srv instrumented with in-memory
+// trace and metric readers.
+func newTracedClient(t *testing.T, srv *difytest.Server) (*dify.Client, *tracetest.SpanRecorder, *sdkmetric.ManualReader) {
+    t.Helper()
+    spans := tracetest.NewSpanRecorder()
+    reader := sdkmetric.NewManualReader()
+    mw, err := otel.Middleware(
+        otel.WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))),
+        otel.WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+    )
+    if err != nil {
+        t.Fatalf("Middleware: %v", err)
+    }
+    client := srv.Client()
+    client.Use(mw)
+    return client, spans, reader
+}
+
+// collect returns the metrics recorded by reader, by name.
+func collect(t *testing.T, reader *sdkmetric.ManualReader) map[string]metricdata.Aggregation {
+    t.Helper()
+    var rm metricdata.ResourceMetrics
+    if err := reader.Collect(context.Background(), &rm); err != nil {
+        t.Fatalf("Collect: %v", err)
+    }
+    metrics := make(map[string]metricdata.Aggregation)
+    for _, scope := range rm.ScopeMetrics {
+        for _, m := range scope.Metrics {
+            metrics[m.Name] = m.Data
+        }
+    }
+    return metrics
+}
+
+// sums returns the data points of an int64 counter keyed by the value of
+// attribute key.
+func sums(data metricdata.Aggregation, key attribute.Key) map[string]int64 {
+    got := make(map[string]int64)
+    sum, _ := data.(metricdata.Sum[int64])
+    for _, point := range sum.DataPoints {
+        value, _ := point.Attributes.Value(key)
+        got[value.Emit()] += point.Value
+    }
+    return got
+}
+
+func TestMiddlewareTracesWorkflow(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    srv.On(difytest.RunWorkflow, difytest.Stream(difytest.WorkflowEvents(map[string]any{"result": "done"}, 42)...))
+    client, spans, reader := newTracedClient(t, srv)
+
+    _, events, err := client.RunWorkflow(context.Background(), dify.WorkflowRunRequest{ResponseMode: "streaming", User: "alice"})
+    if err != nil {
+        t.Fatalf("RunWorkflow: %v", err)
+    }
+    for range events {
+    }
+
+    ended := spans.Ended()
+    if len(ended) != 3 {
+        t.Fatalf("ended %d spans, want the call and two nodes", len(ended))
+    }
+    call := ended[2]
+    if call.Name() != "dify.RunWorkflow" || call.SpanKind() != trace.SpanKindClient || call.Status().Code == codes.Error {
+        t.Errorf("call span = %s (%s, %v)", call.Name(), call.SpanKind(), call.Status())
+    }
+    attrs := make(map[attribute.Key]attribute.Value)
+    for _, kv := range call.Attributes() {
+        attrs[kv.Key] = kv.Value
+    }
+    if attrs["dify.workflow_run_id"].AsString() != "run-1" || attrs["enduser.id"].AsString() != "alice" || attrs["dify.usage.total_tokens"].AsInt64() != 42 {
+        t.Errorf("call span attributes = %v", call.Attributes())
+    }
+    for i, want := range []string{"dify.node Start", "dify.node End"} {
+        node := ended[i]
+        if node.Name() != want || node.Parent().SpanID() != call.SpanContext().SpanID() {
+            t.Errorf("span %d = %s with parent %s, want %s under the call span", i, node.Name(), node.Parent().SpanID(), want)
+        }
+    }
+
+    req := srv.ExpectLast(t, difytest.RunWorkflow)
+    want := fmt.Sprintf("00-%s-%s-01", call.SpanContext().TraceID(), call.SpanContext().SpanID())
+    if got := req.Header.Get("Traceparent"); got != want {
+        t.Errorf("traceparent = %q, want %q", got, want)
+    }
+
+    metrics := collect(t, reader)
+    duration, _ := metrics["dify.client.duration"].(metricdata.Histogram[float64])
+    if len(duration.DataPoints) != 1 || duration.DataPoints[0].Count != 1 {
+        t.Errorf("duration = %+v, want one call", duration.DataPoints)
+    }
+    if got := sums(metrics["dify.client.tokens"], "dify.token.type"); got["total"] != 42 {
+        t.Errorf("tokens = %v, want 42 in total", got)
+    }
+}
+
+func TestMiddlewareRecordsErrors(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    srv.On(difytest.ChatMessages, difytest.Error(http.StatusNotFound, "not_found", "Conversation Not Exists."))
+    client, spans, reader := newTracedClient(t, srv)
+
+    _, _, err := client.SendChatMessage(context.Background(), dify.ChatMessageRequest{Query: "hi", ResponseMode: "blocking", User: "alice"})
+    if err == nil {
+        t.Fatal("SendChatMessage returned no error")
+    }
+
+    ended := spans.Ended()
+    if len(ended) != 1 || ended[0].Status().Code != codes.Error || len(ended[0].Events()) == 0 {
+        t.Fatalf("spans = %v, want one failed span with the error recorded", ended)
+    }
+    if got := sums(collect(t, reader)["dify.client.errors"], "error.type"); got["not_found"] != 1 {
+        t.Errorf("errors = %v, want one not_found", got)
+    }
+}
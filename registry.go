@@ -0,0 +1,285 @@
+package dify
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "slices"
+    "sort"
+    "strings"
+    "sync"
+
+    "gopkg.in/yaml.v3"
+)
+
+// DefaultBaseURL is the Dify cloud API, used for apps configured without a
+// base URL.
+const DefaultBaseURL = "https://api.dify.ai/v1"
+
+// ErrUnknownApp is returned by AppRegistry.Client for unregistered names.
+var ErrUnknownApp = errors.New("unknown app")
+
+// AppMode is the type of a Dify app. It determines which endpoints the
+// app's API key may call.
+type AppMode string
+
+const (
+    AppModeChat         AppMode = "chat"
+    AppModeAgentChat    AppMode = "agent-chat"
+    AppModeAdvancedChat AppMode = "advanced-chat"
+    AppModeCompletion   AppMode = "completion"
+    AppModeWorkflow     AppMode = "workflow"
+)
+
+// chatModes are the modes served by the chat-messages endpoints.
+var chatModes = []AppMode{AppModeChat, AppModeAgentChat, AppModeAdvancedChat}
+
+// endpointModes lists the app modes allowed to call mode-specific endpoints.
+// Endpoints not listed are available to every mode.
+var endpointModes = map[string][]AppMode{
+    "SendChatMessage":            chatModes,
+    "StopTask":                   chatModes,
+    "GetConversationVariables":   chatModes,
+    "UpdateConversationVariable": chatModes,
+    "SendCompletionMessage":      {AppModeCompletion},
+    "StopCompletionMessage":      {AppModeCompletion},
+    "RunWorkflow":                {AppModeWorkflow},
+    "GetWorkflowStatus":          {AppModeWorkflow},
+}
+
+// valid reports whether m is a known app mode.
+func (m AppMode) valid() bool {
+    switch m {
+    case AppModeChat, AppModeAgentChat, AppModeAdvancedChat, AppModeCompletion, AppModeWorkflow:
+        return true
+    }
+    return false
+}
+
+// checkAppMode rejects endpoints the client's app mode does not serve,
+// before anything is sent.
+func (c *Client) checkAppMode(endpointName string) error {
+    modes, ok := endpointModes[endpointName]
+    if c.appMode == "" || !ok || slices.Contains(modes, c.appMode) {
+        return nil
+    }
+    return fmt.Errorf("%w: %s cannot be called on %s app %q", ErrAppModeMismatch, endpointName, c.appMode, c.appName)
+}
+
+// AppConfig describes one Dify app.
+type AppConfig struct {
+    Name    string  `json:"name" yaml:"name"`
+    BaseURL string  `json:"base_url" yaml:"base_url"`
+    APIKey  string  `json:"api_key" yaml:"api_key"`
+    Mode    AppMode `json:"mode" yaml:"mode"`
+}
+
+// appsFile is the layout of registry files:
+//
+//    base_url: https://dify.example.com/v1
+//    apps:
+//      support-bot:
+//        api_key: ${SUPPORT_BOT_KEY}
+//        mode: chat
+//
+// The top-level base_url applies to apps that set none. Values may refer to
+// environment variables as $NAME or ${NAME}.
+type appsFile struct {
+    BaseURL string               `json:"base_url" yaml:"base_url"`
+    Apps    map[string]AppConfig `json:"apps" yaml:"apps"`
+}
+
+// AppRegistry holds named app configs and hands out one client per app.
+// Clients check each call against their app's mode and return
+// ErrAppModeMismatch for endpoints it does not serve, e.g. RunWorkflow on a
+// chat app.
+type AppRegistry struct {
+    mu      sync.Mutex
+    opts    []Option
+    apps    map[string]AppConfig
+    clients map[string]*Client
+}
+
+// NewAppRegistry returns an empty registry. The options are applied to
+// every client it creates.
+func NewAppRegistry(opts ...Option) *AppRegistry {
+    return &AppRegistry{
+        opts:    opts,
+        apps:    make(map[string]AppConfig),
+        clients: make(map[string]*Client),
+    }
+}
+
+// Register adds or replaces an app. An empty BaseURL means DefaultBaseURL.
+func (r *AppRegistry) Register(app AppConfig) error {
+    if app.Name == "" {
+        return errors.New("app name is required")
+    }
+    if app.APIKey == "" {
+        return fmt.Errorf("app %q: api_key is required", app.Name)
+    }
+    if !app.Mode.valid() {
+        return fmt.Errorf("app %q: invalid mode %q", app.Name, app.Mode)
+    }
+    if app.BaseURL == "" {
+        app.BaseURL = DefaultBaseURL
+    }
+
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.apps[app.Name] = app
+    delete(r.clients, app.Name)
+    return nil
+}
+
+// LoadFile registers the apps of a YAML (.yaml, .yml) or JSON file.
+func (r *AppRegistry) LoadFile(path string) error {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    switch ext := strings.ToLower(filepath.Ext(path)); ext {
+    case ".yaml", ".yml":
+        return r.LoadYAML(data)
+    case ".json":
+        return r.LoadJSON(data)
+    default:
+        return fmt.Errorf("unsupported app registry file type %q", ext)
+    }
+}
+
+// LoadYAML registers the apps of a YAML document.
+func (r *AppRegistry) LoadYAML(data []byte) error {
+    var file appsFile
+    if err := yaml.Unmarshal(data, &file); err != nil {
+        return err
+    }
+    return r.registerFile(file)
+}
+
+// LoadJSON registers the apps of a JSON document.
+func (r *AppRegistry) LoadJSON(data []byte) error {
+    var file appsFile
+    if err := json.Unmarshal(data, &file); err != nil {
+        return err
+    }
+    return r.registerFile(file)
+}
+
+// registerFile registers the apps of a decoded registry file in name order.
+func (r *AppRegistry) registerFile(file appsFile) error {
+    names := make([]string, 0, len(file.Apps))
+    for name := range file.Apps {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    for _, name := range names {
+        app := file.Apps[name]
+        app.Name = name
+        app.BaseURL = os.ExpandEnv(app.BaseURL)
+        if app.BaseURL == "" {
+            app.BaseURL = os.ExpandEnv(file.BaseURL)
+        }
+        app.APIKey = os.ExpandEnv(app.APIKey)
+        app.Mode = AppMode(os.ExpandEnv(string(app.Mode)))
+        if err := r.Register(app); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// LoadEnv registers apps from environment variables of the form
+// <prefix><NAME>_API_KEY, <prefix><NAME>_MODE and optionally
+// <prefix><NAME>_BASE_URL. With prefix "DIFY_APP_", the variable
+// DIFY_APP_SUPPORT_BOT_API_KEY configures the app "support-bot".
+//
+// Variables without a matching _API_KEY are ignored, since the prefix may
+// be shared with unrelated settings. Apps that cannot be registered are
+// skipped and reported in the returned error; the others are registered.
+func (r *AppRegistry) LoadEnv(prefix string) error {
+    apps := make(map[string]AppConfig)
+    for _, env := range os.Environ() {
+        key, value, _ := strings.Cut(env, "=")
+        rest, ok := strings.CutPrefix(key, prefix)
+        if !ok {
+            continue
+        }
+        for _, suffix := range []string{"_API_KEY", "_BASE_URL", "_MODE"} {
+            envName, ok := strings.CutSuffix(rest, suffix)
+            if !ok || envName == "" {
+                continue
+            }
+            app := apps[envName]
+            switch suffix {
+            case "_API_KEY":
+                app.APIKey = value
+            case "_BASE_URL":
+                app.BaseURL = value
+            case "_MODE":
+                app.Mode = AppMode(value)
+            }
+            apps[envName] = app
+            break
+        }
+    }
+
+    envNames := make([]string, 0, len(apps))
+    for envName := range apps {
+        envNames = append(envNames, envName)
+    }
+    sort.Strings(envNames)
+
+    var errs error
+    for _, envName := range envNames {
+        app := apps[envName]
+        if app.APIKey == "" {
+            continue
+        }
+        app.Name = strings.ReplaceAll(strings.ToLower(envName), "_", "-")
+        errs = errors.Join(errs, r.Register(app))
+    }
+    return errs
+}
+
+// Names returns the registered app names in sorted order.
+func (r *AppRegistry) Names() []string {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    names := make([]string, 0, len(r.apps))
+    for name := range r.apps {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// App returns the config of a registered app.
+func (r *AppRegistry) App(name string) (AppConfig, bool) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    app, ok := r.apps[name]
+    return app, ok
+}
+
+// Client returns the client of a registered app, creating it on first use.
+// The client only accepts calls its app's mode serves.
+func (r *AppRegistry) Client(name string) (*Client, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if client, ok := r.clients[name]; ok {
+        return client, nil
+    }
+    app, ok := r.apps[name]
+    if !ok {
+        return nil, fmt.Errorf("%w: %q", ErrUnknownApp, name)
+    }
+
+    opts := append(slices.Clip(r.opts), WithApp(app.Name, app.Mode))
+    client := NewClient(app.BaseURL, app.APIKey, opts...)
+    r.clients[name] = client
+    return client, nil
+}
@@ -0,0 +1,312 @@
+package dify
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "mime/multipart"
+    "net/http"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/hashicorp/go-retryablehttp"
+)
+
+// endpoint describes a single Dify API operation.
+type endpoint struct {
+    // name identifies the operation, e.g. "SendChatMessage". It is the
+    // name of the Client method that calls it.
+    name   string
+    method string
+    path   string
+    query  url.Values
+}
+
+// requestBody is implemented by request bodies that are not sent as JSON.
+type requestBody interface {
+    encode() (body io.Reader, contentType string, err error)
+}
+
+// noBody is the request body of endpoints that send none.
+type noBody struct{}
+
+// encode implements requestBody.
+func (noBody) encode() (io.Reader, string, error) {
+    return nil, "", nil
+}
+
+// multipartFile is a multipart form carrying a single local file.
+type multipartFile struct {
+    path   string
+    name   string
+    fields map[string]string
+}
+
+// encode implements requestBody.
+func (m multipartFile) encode() (io.Reader, string, error) {
+    // Open the file
+    file, err := os.Open(m.path)
+    if err != nil {
+        return nil, "", err
+    }
+    defer file.Close()
+
+    name := m.name
+    if name == "" {
+        name = filepath.Base(m.path)
+    }
+
+    // Create a buffer to write our multipart form
+    var buf bytes.Buffer
+    writer := multipart.NewWriter(&buf)
+
+    // Add the form fields
+    for key, value := range m.fields {
+        if err := writer.WriteField(key, value); err != nil {
+            return nil, "", err
+        }
+    }
+
+    // Add the file
+    part, err := writer.CreateFormFile("file", name)
+    if err != nil {
+        return nil, "", err
+    }
+    if _, err := io.Copy(part, file); err != nil {
+        return nil, "", err
+    }
+
+    // Close the writer to finalize the multipart form
+    if err := writer.Close(); err != nil {
+        return nil, "", err
+    }
+    return &buf, writer.FormDataContentType(), nil
+}
+
+// encodeBody returns the wire form of a request body.
+func encodeBody(reqBody any) (io.Reader, string, error) {
+    if body, ok := reqBody.(requestBody); ok {
+        return body.encode()
+    }
+    bodyBytes, err := json.Marshal(reqBody)
+    if err != nil {
+        return nil, "", err
+    }
+    return bytes.NewReader(bodyBytes), "application/json", nil
+}
+
+// do sends a request and decodes the JSON response into Resp.
+// Empty response bodies, such as 204 No Content, decode to the zero Resp.
+func do[Req, Resp any](ctx context.Context, c *Client, ep endpoint, reqBody Req) (*Resp, error) {
+    resp, call, err := c.send(ctx, ep, reqBody, false)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    var respBody Resp
+    if err := json.NewDecoder(resp.Body).Decode(&respBody); err != nil && err != io.EOF {
+        call.complete(nil, err)
+        return nil, err
+    }
+    call.complete(&respBody, nil)
+    return &respBody, nil
+}
+
+// streamChunk is implemented by the event types of streaming endpoints.
+type streamChunk[Chunk any] interface {
+    // Err returns the error carried by an "error" event.
+    Err() error
+    // errorEvent wraps an error that occurs while reading the stream.
+    errorEvent(err error) Chunk
+}
+
+// stream sends a request and decodes the server-sent events of the response.
+// Errors before the stream starts are returned directly; errors while reading
+// it are delivered as an "error" event before the channel is closed.
+func stream[Chunk streamChunk[Chunk], Req any](ctx context.Context, c *Client, ep endpoint, reqBody Req) (<-chan Chunk, error) {
+    resp, call, err := c.send(ctx, ep, reqBody, true)
+    if err != nil {
+        return nil, err
+    }
+
+    streamChan := make(chan Chunk)
+    go func() {
+        var streamErr error
+        defer close(streamChan)
+        defer func() { call.complete(nil, streamErr) }()
+        defer resp.Body.Close()
+
+        emit := func(chunk Chunk) bool {
+            call.event(chunk)
+            if err := chunk.Err(); err != nil {
+                streamErr = err
+            }
+            select {
+            case streamChan <- chunk:
+                return true
+            case <-ctx.Done():
+                streamErr = ctx.Err()
+                return false
+            }
+        }
+
+        reader := bufio.NewReader(resp.Body)
+        for {
+            line, err := reader.ReadString('\n')
+            if data, ok := sseData(line); ok {
+                var chunk Chunk
+                if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+                    chunk = chunk.errorEvent(fmt.Errorf("decode stream event: %w", err))
+                }
+                if !emit(chunk) {
+                    return
+                }
+            }
+            if err != nil {
+                if ctx.Err() != nil {
+                    streamErr = ctx.Err()
+                } else if err != io.EOF {
+                    var chunk Chunk
+                    emit(chunk.errorEvent(err))
+                }
+                return
+            }
+        }
+    }()
+    return streamChan, nil
+}
+
+// sseData returns the payload of a server-sent event "data:" line.
+func sseData(line string) (string, bool) {
+    line = strings.TrimRight(line, "\r\n")
+    if !strings.HasPrefix(line, "data:") {
+        return "", false
+    }
+    data := strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+    return data, data != ""
+}
+
+// send runs a call through the middleware chain and returns the response
+// once a 2xx status is received. Failed calls are completed before returning.
+func (c *Client) send(ctx context.Context, ep endpoint, reqBody any, streaming bool) (*http.Response, *Call, error) {
+    call := &Call{
+        Endpoint:  ep.name,
+        App:       c.appName,
+        AppMode:   c.appMode,
+        Method:    ep.method,
+        Path:      ep.path,
+        Query:     ep.query,
+        Request:   reqBody,
+        Streaming: streaming,
+        Header:    make(http.Header),
+    }
+
+    c.applyDefaultUser(call)
+    if c.log != nil {
+        c.log.start(ctx, call)
+    }
+    if err := c.checkAppMode(call.Endpoint); err != nil {
+        call.complete(nil, err)
+        return nil, nil, err
+    }
+    if err := c.acquireLimits(ctx, call); err != nil {
+        call.complete(nil, err)
+        return nil, nil, err
+    }
+
+    roundTrip := c.roundTrip
+    for i := len(c.middlewares) - 1; i >= 0; i-- {
+        roundTrip = c.middlewares[i](roundTrip)
+    }
+
+    resp, err := roundTrip(ctx, call)
+    if err != nil {
+        call.complete(nil, err)
+        return nil, nil, err
+    }
+    return resp, call, nil
+}
+
+// roundTrip is the innermost RoundTrip. It sends the call with the client's
+// API key, or with a key from its KeyPool, switching keys when one is
+// rejected.
+func (c *Client) roundTrip(ctx context.Context, call *Call) (*http.Response, error) {
+    if c.keys == nil {
+        return c.roundTripKey(ctx, call, c.APIKey)
+    }
+
+    tried := make(map[*poolKey]bool)
+    for {
+        key, err := c.keys.acquire(tried)
+        if err != nil {
+            return nil, err
+        }
+        tried[key] = true
+
+        keyCtx := ctx
+        if len(tried) < c.keys.Len() {
+            keyCtx = context.WithValue(ctx, keyFailoverKey{}, true)
+        }
+        resp, err := c.roundTripKey(keyCtx, call, key.key)
+        if err != nil {
+            c.keys.release(key)
+            if rejectsKey(err) {
+                c.keys.quarantine(key, err)
+                if len(tried) < c.keys.Len() {
+                    continue
+                }
+            }
+            return nil, err
+        }
+        call.OnComplete(func(any, error) { c.keys.release(key) })
+        return resp, nil
+    }
+}
+
+// roundTripKey encodes the request, executes it with the given API key and
+// maps non-2xx responses to *APIError.
+func (c *Client) roundTripKey(ctx context.Context, call *Call, apiKey string) (*http.Response, error) {
+    body, contentType, err := encodeBody(call.Request)
+    if err != nil {
+        return nil, err
+    }
+
+    endpointURL := c.buildURL(call.Path)
+    if len(call.Query) > 0 {
+        endpointURL += "?" + call.Query.Encode()
+    }
+
+    // Create new request
+    var rawBody interface{}
+    if body != nil {
+        rawBody = body
+    }
+    req, err := retryablehttp.NewRequestWithContext(ctx, call.Method, endpointURL, rawBody)
+    if err != nil {
+        return nil, err
+    }
+
+    // Add headers
+    c.addHeaders(req, apiKey, contentType)
+    for key, values := range call.Header {
+        req.Header[key] = values
+    }
+
+    // Execute request
+    resp, err := c.retryClient(ctx).Do(req)
+    if err != nil {
+        return nil, err
+    }
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        defer resp.Body.Close()
+        return nil, newAPIError(resp)
+    }
+    return resp, nil
+}
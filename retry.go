@@ -0,0 +1,169 @@
+package dify
+
+import (
+    "context"
+    "errors"
+    "math/rand/v2"
+    "net"
+    "net/http"
+    "strconv"
+    "syscall"
+    "time"
+
+    "github.com/hashicorp/go-retryablehttp"
+)
+
+// RetryPolicy configures how failed requests are retried.
+//
+// A request is retried when the connection fails before any bytes are sent,
+// or when Dify answers 429, 502, 503 or 504. Other failures, including a
+// transport error after the request was written, are never retried, so a
+// chat message that may already be generating is not sent twice. Once a
+// streaming response has begun it is never retried either.
+type RetryPolicy struct {
+    // MaxRetries is the number of retries after the first attempt.
+    MaxRetries int
+    // WaitMin and WaitMax bound the jittered exponential backoff between
+    // attempts.
+    WaitMin time.Duration
+    WaitMax time.Duration
+    // MaxRetryAfter caps how long a Retry-After header may ask to wait.
+    // Responses asking for longer are returned without retrying. Zero
+    // means no cap.
+    MaxRetryAfter time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy used by NewClient.
+var DefaultRetryPolicy = RetryPolicy{
+    MaxRetries:    3,
+    WaitMin:       500 * time.Millisecond,
+    WaitMax:       2 * time.Second,
+    MaxRetryAfter: 30 * time.Second,
+}
+
+// NoRetry is a RetryPolicy that sends each request once.
+var NoRetry = RetryPolicy{}
+
+// retryPolicyKey is the context key of a per-call RetryPolicy.
+type retryPolicyKey struct{}
+
+// WithRetry returns a context that makes calls using it retry according to
+// policy instead of the client's policy.
+func WithRetry(ctx context.Context, policy RetryPolicy) context.Context {
+    return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+// keyFailoverKey marks the context of requests sent with a pooled key while
+// other keys remain untried. Their 401 and 429 responses are returned at
+// once so that the next key is used instead of retrying the rejected one.
+type keyFailoverKey struct{}
+
+// retryPolicyFrom returns the per-call RetryPolicy carried by ctx, if any.
+func retryPolicyFrom(ctx context.Context) (RetryPolicy, bool) {
+    policy, ok := ctx.Value(retryPolicyKey{}).(RetryPolicy)
+    return policy, ok
+}
+
+// apply installs the policy on a retryablehttp client.
+func (p RetryPolicy) apply(client *retryablehttp.Client) {
+    client.RetryMax = p.MaxRetries
+    client.RetryWaitMin = p.WaitMin
+    client.RetryWaitMax = p.WaitMax
+    client.CheckRetry = p.checkRetry
+    client.Backoff = p.backoff
+}
+
+// checkRetry implements retryablehttp.CheckRetry.
+func (p RetryPolicy) checkRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+    if ctx.Err() != nil {
+        return false, ctx.Err()
+    }
+    if err != nil {
+        return isConnectError(err), nil
+    }
+
+    if ctx.Value(keyFailoverKey{}) != nil && rejectsStatus(resp.StatusCode) {
+        return false, nil
+    }
+
+    switch resp.StatusCode {
+    case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+        if wait, ok := retryAfter(resp); ok && p.MaxRetryAfter > 0 && wait > p.MaxRetryAfter {
+            return false, nil
+        }
+        return true, nil
+    case http.StatusBadGateway, http.StatusGatewayTimeout:
+        return true, nil
+    }
+    return false, nil
+}
+
+// backoff implements retryablehttp.Backoff. It honors Retry-After and
+// otherwise waits a random duration between half and all of
+// min*2^attempt, capped at max.
+func (p RetryPolicy) backoff(min, max time.Duration, attempt int, resp *http.Response) time.Duration {
+    if wait, ok := retryAfter(resp); ok {
+        return wait
+    }
+
+    wait := max
+    if attempt < 32 && min<<attempt > 0 && min<<attempt < max {
+        wait = min << attempt
+    }
+    if wait <= 0 {
+        return 0
+    }
+    return wait/2 + rand.N(wait/2+1)
+}
+
+// retryAfter parses the Retry-After header of a 429 or 503 response, given
+// either in seconds or as an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+    if resp == nil || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+        return 0, false
+    }
+    value := resp.Header.Get("Retry-After")
+    if value == "" {
+        return 0, false
+    }
+    if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+        return time.Duration(seconds) * time.Second, true
+    }
+    if date, err := http.ParseTime(value); err == nil {
+        return max(time.Until(date), 0), true
+    }
+    return 0, false
+}
+
+// isConnectError reports whether err happened while establishing the
+// connection, before any part of the request was written.
+func isConnectError(err error) bool {
+    var opErr *net.OpError
+    if errors.As(err, &opErr) && opErr.Op == "dial" {
+        return true
+    }
+    var dnsErr *net.DNSError
+    if errors.As(err, &dnsErr) {
+        return true
+    }
+    return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// retryClient returns the retryablehttp client for a call: the client's own,
+// or a copy sharing its transport when ctx carries a per-call RetryPolicy.
+func (c *Client) retryClient(ctx context.Context) *retryablehttp.Client {
+    policy, ok := retryPolicyFrom(ctx)
+    if !ok {
+        return c.HTTPClient
+    }
+    client := &retryablehttp.Client{
+        HTTPClient:      c.HTTPClient.HTTPClient,
+        Logger:          c.HTTPClient.Logger,
+        RequestLogHook:  c.HTTPClient.RequestLogHook,
+        ResponseLogHook: c.HTTPClient.ResponseLogHook,
+        ErrorHandler:    c.HTTPClient.ErrorHandler,
+        PrepareRetry:    c.HTTPClient.PrepareRetry,
+    }
+    policy.apply(client)
+    return client
+}
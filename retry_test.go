@@ -0,0 +1,137 @@
+package dify_test
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    dify "github.com/barlowliu/dify-go"
+    "github.com/barlowliu/dify-go/difytest"
+)
+
+// fastRetry retries twice without waiting long.
+var fastRetry = dify.RetryPolicy{
+    MaxRetries:    2,
+    WaitMin:       time.Millisecond,
+    WaitMax:       5 * time.Millisecond,
+    MaxRetryAfter: 2 * time.Second,
+}
+
+func TestRetryStatuses(t *testing.T) {
+    tests := []struct {
+        status  int
+        retried bool
+    }{
+        {http.StatusBadGateway, true},
+        {http.StatusGatewayTimeout, true},
+        {http.StatusServiceUnavailable, true},
+        {http.StatusTooManyRequests, true},
+        {http.StatusInternalServerError, false},
+        {http.StatusBadRequest, false},
+    }
+    for _, tt := range tests {
+        t.Run(http.StatusText(tt.status), func(t *testing.T) {
+            srv := difytest.NewServer()
+            defer srv.Close()
+            srv.On(difytest.ChatMessages, difytest.Error(tt.status, "", "failed"))
+            client := srv.Client(dify.WithRetryPolicy(fastRetry))
+
+            err := sendChat(client, "alice")
+            if tt.retried && err != nil {
+                t.Errorf("retried call: %v", err)
+            }
+            want := 1
+            if tt.retried {
+                want = 2
+            } else if err == nil {
+                t.Error("call not retried succeeded")
+            }
+            srv.ExpectRequests(t, difytest.ChatMessages, want)
+        })
+    }
+}
+
+func TestRetryAfter(t *testing.T) {
+    for _, status := range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+        t.Run(http.StatusText(status), func(t *testing.T) {
+            t.Parallel()
+            srv := difytest.NewServer()
+            defer srv.Close()
+            honored := difytest.Error(status, "", "busy")
+            honored.Header = http.Header{"Retry-After": {"1"}}
+            srv.On(difytest.ChatMessages, honored)
+            client := srv.Client(dify.WithRetryPolicy(fastRetry))
+
+            start := time.Now()
+            if err := sendChat(client, "alice"); err != nil {
+                t.Fatalf("SendChatMessage: %v", err)
+            }
+            if elapsed := time.Since(start); elapsed < time.Second {
+                t.Errorf("retried after %v, want the 1s of Retry-After", elapsed)
+            }
+            srv.ExpectRequests(t, difytest.ChatMessages, 2)
+
+            // Waits beyond MaxRetryAfter are not retried.
+            srv.Reset()
+            capped := difytest.Error(status, "", "busy")
+            capped.Header = http.Header{"Retry-After": {"5"}}
+            srv.On(difytest.ChatMessages, capped)
+            start = time.Now()
+            var apiErr *dify.APIError
+            if err := sendChat(client, "alice"); !errors.As(err, &apiErr) || apiErr.StatusCode != status {
+                t.Errorf("call asked to wait 5s = %v, want the %d response", err, status)
+            }
+            if elapsed := time.Since(start); elapsed > time.Second {
+                t.Errorf("returned after %v, want at once", elapsed)
+            }
+            srv.ExpectRequests(t, difytest.ChatMessages, 1)
+        })
+    }
+}
+
+func TestRetrySkipsWrittenRequests(t *testing.T) {
+    var requests atomic.Int32
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        requests.Add(1)
+        // Drop the connection after reading the request.
+        panic(http.ErrAbortHandler)
+    }))
+    defer srv.Close()
+    client := dify.NewClient(srv.URL, "app-test", dify.WithRetryPolicy(fastRetry))
+
+    if err := sendChat(client, "alice"); err == nil {
+        t.Fatal("SendChatMessage succeeded on a dropped connection")
+    }
+    if n := requests.Load(); n != 1 {
+        t.Errorf("sent the chat message %d times, want once", n)
+    }
+}
+
+func TestWithRetry(t *testing.T) {
+    srv := difytest.NewServer()
+    defer srv.Close()
+    badGateway := difytest.Error(http.StatusBadGateway, "", "bad gateway")
+
+    // A per-call policy retries on a client that does not.
+    srv.On(difytest.ChatMessages, badGateway)
+    client := srv.Client()
+    ctx := dify.WithRetry(context.Background(), fastRetry)
+    if _, _, err := client.SendChatMessage(ctx, dify.ChatMessageRequest{Query: "hi", ResponseMode: "blocking", User: "alice"}); err != nil {
+        t.Fatalf("call with a retry policy: %v", err)
+    }
+    srv.ExpectRequests(t, difytest.ChatMessages, 2)
+
+    // And NoRetry turns retries off for one call.
+    srv.Reset()
+    srv.On(difytest.ChatMessages, badGateway)
+    client = srv.Client(dify.WithRetryPolicy(fastRetry))
+    ctx = dify.WithRetry(context.Background(), dify.NoRetry)
+    if _, _, err := client.SendChatMessage(ctx, dify.ChatMessageRequest{Query: "hi", ResponseMode: "blocking", User: "alice"}); !errors.Is(err, dify.ErrServerError) {
+        t.Errorf("call without retries = %v, want the 502", err)
+    }
+    srv.ExpectRequests(t, difytest.ChatMessages, 1)
+}
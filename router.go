@@ -0,0 +1,397 @@
+package dify
+
+import (
+    "container/list"
+    "context"
+    "errors"
+    "slices"
+    "sort"
+    "sync"
+    "time"
+)
+
+// ErrNoRoute is returned by a Router without routes.
+var ErrNoRoute = errors.New("no route")
+
+// ErrUnknownTask is returned when stopping a task the Router is not
+// streaming.
+var ErrUnknownTask = errors.New("unknown task")
+
+// DefaultFallbackCodes are the Dify error codes on which a Router falls
+// back to the next route.
+var DefaultFallbackCodes = []string{
+    "app_unavailable",
+    "provider_not_initialize",
+    "provider_quota_exceeded",
+    "model_currently_not_support",
+}
+
+// Route is a client a Router may send calls to.
+type Route struct {
+    // Name identifies the route, e.g. "primary" or "backup".
+    Name   string
+    Client *Client
+    // Priority orders the routes; lower values are tried first.
+    Priority int
+}
+
+// Router sends calls to the first healthy route in priority order and
+// falls back to the next one when a call fails before its response starts:
+// on connection errors, 5xx responses, open circuit breakers and the
+// APIError codes in FallbackCodes. Once a stream has started it is never
+// moved to another route.
+//
+// Chat conversations stay on the route that started them, since
+// conversation IDs are only known to one app; a call continuing a
+// conversation fails instead of falling back. Conversations the Router does
+// not remember, e.g. after a restart, are continued on the primary route.
+type Router struct {
+    // FallbackCodes are the Dify error codes that trigger a fallback.
+    // Defaults to DefaultFallbackCodes.
+    FallbackCodes []string
+    // UnhealthyAfter is the number of consecutive fallbacks after which a
+    // route is skipped. Defaults to 3.
+    UnhealthyAfter int
+    // RetryAfter is how long an unhealthy route is skipped before it is
+    // tried again. Defaults to 30 seconds.
+    RetryAfter time.Duration
+    // MaxConversations bounds the number of conversations whose route is
+    // remembered; the least recently used are forgotten first. Defaults to
+    // 10000.
+    MaxConversations int
+
+    mu            sync.Mutex
+    routes        []*routeState
+    conversations map[string]*list.Element
+    recent        *list.List
+    tasks         map[string]*routeState
+}
+
+// pinnedConversation is an entry of Router.recent.
+type pinnedConversation struct {
+    id    string
+    route *routeState
+}
+
+// routeState is a route and its health.
+type routeState struct {
+    Route
+    failures int
+    failedAt time.Time
+}
+
+// NewRouter returns a Router over the given routes.
+func NewRouter(routes ...Route) *Router {
+    r := &Router{
+        conversations: make(map[string]*list.Element),
+        recent:        list.New(),
+        tasks:         make(map[string]*routeState),
+    }
+    for _, route := range routes {
+        r.routes = append(r.routes, &routeState{Route: route})
+    }
+    sort.SliceStable(r.routes, func(i, j int) bool {
+        return r.routes[i].Priority < r.routes[j].Priority
+    })
+    return r
+}
+
+// RouteHealth reports the health of a route.
+type RouteHealth struct {
+    Name    string
+    Healthy bool
+    // Failures is the number of consecutive calls that fell back.
+    Failures int
+}
+
+// Health returns the health of the routes in priority order.
+func (r *Router) Health() []RouteHealth {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    health := make([]RouteHealth, 0, len(r.routes))
+    for _, route := range r.routes {
+        health = append(health, RouteHealth{
+            Name:     route.Name,
+            Healthy:  r.healthy(route, time.Now()),
+            Failures: route.failures,
+        })
+    }
+    return health
+}
+
+// SendChatMessage sends a chat message through the first available route.
+// Messages continuing a conversation go to the route that started it, or to
+// the primary route if it is not known, and never fall back.
+func (r *Router) SendChatMessage(ctx context.Context, reqBody ChatMessageRequest) (*ChatCompletionResponse, <-chan ChunkChatCompletionResponse, error) {
+    var pinned *routeState
+    if reqBody.ConversationID != "" {
+        pinned = r.conversationRoute(reqBody.ConversationID)
+        if pinned == nil {
+            pinned = r.primary()
+            if pinned == nil {
+                return nil, nil, ErrNoRoute
+            }
+        }
+    }
+
+    var resp *ChatCompletionResponse
+    var streamChan <-chan ChunkChatCompletionResponse
+    route, err := r.call(pinned, func(client *Client) error {
+        var err error
+        resp, streamChan, err = client.SendChatMessage(ctx, reqBody)
+        return err
+    })
+    if err != nil {
+        return nil, nil, err
+    }
+
+    if resp != nil {
+        r.remember(resp.ConversationID, route)
+    }
+    if streamChan != nil {
+        streamChan = r.watchStream(ctx, streamChan, route, true)
+    }
+    return resp, streamChan, nil
+}
+
+// SendCompletionMessage sends a completion message through the first
+// available route.
+func (r *Router) SendCompletionMessage(ctx context.Context, reqBody CompletionMessageRequest) (*CompletionResponse, <-chan ChunkChatCompletionResponse, error) {
+    var resp *CompletionResponse
+    var streamChan <-chan ChunkChatCompletionResponse
+    route, err := r.call(nil, func(client *Client) error {
+        var err error
+        resp, streamChan, err = client.SendCompletionMessage(ctx, reqBody)
+        return err
+    })
+    if err != nil {
+        return nil, nil, err
+    }
+    if streamChan != nil {
+        streamChan = r.watchStream(ctx, streamChan, route, false)
+    }
+    return resp, streamChan, nil
+}
+
+// StopTask stops a chat stream started through the Router, on the route
+// that serves it. It returns ErrUnknownTask for tasks the Router is not
+// streaming.
+func (r *Router) StopTask(ctx context.Context, taskID, user string) (*StopResponse, error) {
+    route := r.taskRoute(taskID)
+    if route == nil {
+        return nil, ErrUnknownTask
+    }
+    return route.Client.StopTask(ctx, taskID, user)
+}
+
+// StopCompletionMessage stops a completion stream started through the
+// Router, on the route that serves it. It returns ErrUnknownTask for tasks
+// the Router is not streaming.
+func (r *Router) StopCompletionMessage(ctx context.Context, taskID, user string) (*StopResponse, error) {
+    route := r.taskRoute(taskID)
+    if route == nil {
+        return nil, ErrUnknownTask
+    }
+    return route.Client.StopCompletionMessage(ctx, taskID, user)
+}
+
+// RunWorkflow runs a workflow through the first available route.
+func (r *Router) RunWorkflow(ctx context.Context, reqBody WorkflowRunRequest) (*WorkflowCompletionResponse, <-chan ChunkCompletionResponse, error) {
+    var resp *WorkflowCompletionResponse
+    var streamChan <-chan ChunkCompletionResponse
+    _, err := r.call(nil, func(client *Client) error {
+        var err error
+        resp, streamChan, err = client.RunWorkflow(ctx, reqBody)
+        return err
+    })
+    return resp, streamChan, err
+}
+
+// call runs fn on the pinned route, or on each route in turn until one
+// succeeds or fails with an error that does not warrant a fallback.
+func (r *Router) call(pinned *routeState, fn func(client *Client) error) (*routeState, error) {
+    routes := []*routeState{pinned}
+    if pinned == nil {
+        routes = r.candidates()
+    }
+    if len(routes) == 0 {
+        return nil, ErrNoRoute
+    }
+
+    var errs []error
+    for _, route := range routes {
+        err := fn(route.Client)
+        if err == nil {
+            r.record(route, false)
+            return route, nil
+        }
+        if !r.shouldFallback(err) {
+            return nil, err
+        }
+        r.record(route, true)
+        errs = append(errs, err)
+        if pinned != nil {
+            break
+        }
+    }
+    if len(errs) == 1 {
+        return nil, errs[0]
+    }
+    return nil, errors.Join(errs...)
+}
+
+// candidates returns the healthy routes in priority order, followed by the
+// unhealthy ones as a last resort.
+func (r *Router) candidates() []*routeState {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    now := time.Now()
+    var healthy, unhealthy []*routeState
+    for _, route := range r.routes {
+        if r.healthy(route, now) {
+            healthy = append(healthy, route)
+        } else {
+            unhealthy = append(unhealthy, route)
+        }
+    }
+    return append(healthy, unhealthy...)
+}
+
+// healthy reports whether a route should be tried. Unhealthy routes become
+// eligible again RetryAfter after their last failure. r.mu must be held.
+func (r *Router) healthy(route *routeState, now time.Time) bool {
+    unhealthyAfter := r.UnhealthyAfter
+    if unhealthyAfter <= 0 {
+        unhealthyAfter = 3
+    }
+    retryAfter := r.RetryAfter
+    if retryAfter <= 0 {
+        retryAfter = 30 * time.Second
+    }
+    return route.failures < unhealthyAfter || now.Sub(route.failedAt) >= retryAfter
+}
+
+// record updates the health of a route after a call.
+func (r *Router) record(route *routeState, failed bool) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if failed {
+        route.failures++
+        route.failedAt = time.Now()
+    } else {
+        route.failures = 0
+    }
+}
+
+// shouldFallback reports whether a failed call may be retried on another
+// route.
+func (r *Router) shouldFallback(err error) bool {
+    if isConnectError(err) || errors.Is(err, ErrServerError) || errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrNoAvailableKey) {
+        return true
+    }
+    var apiErr *APIError
+    if !errors.As(err, &apiErr) {
+        return false
+    }
+    codes := r.FallbackCodes
+    if codes == nil {
+        codes = DefaultFallbackCodes
+    }
+    return slices.Contains(codes, apiErr.Code)
+}
+
+// primary returns the route with the highest priority, or nil.
+func (r *Router) primary() *routeState {
+    if len(r.routes) == 0 {
+        return nil
+    }
+    return r.routes[0]
+}
+
+// conversationRoute returns the route a conversation started on, or nil.
+func (r *Router) conversationRoute(conversationID string) *routeState {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    elem, ok := r.conversations[conversationID]
+    if !ok {
+        return nil
+    }
+    r.recent.MoveToFront(elem)
+    return elem.Value.(*pinnedConversation).route
+}
+
+// remember pins a conversation to a route.
+func (r *Router) remember(conversationID string, route *routeState) {
+    if conversationID == "" {
+        return
+    }
+    maxConversations := r.MaxConversations
+    if maxConversations <= 0 {
+        maxConversations = 10000
+    }
+
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if elem, ok := r.conversations[conversationID]; ok {
+        elem.Value.(*pinnedConversation).route = route
+        r.recent.MoveToFront(elem)
+        return
+    }
+    for r.recent.Len() >= maxConversations {
+        oldest := r.recent.Back()
+        r.recent.Remove(oldest)
+        delete(r.conversations, oldest.Value.(*pinnedConversation).id)
+    }
+    r.conversations[conversationID] = r.recent.PushFront(&pinnedConversation{id: conversationID, route: route})
+}
+
+// taskRoute returns the route streaming a task, or nil.
+func (r *Router) taskRoute(taskID string) *routeState {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return r.tasks[taskID]
+}
+
+// setTask records or, with a nil route, forgets the route streaming a task.
+func (r *Router) setTask(taskID string, route *routeState) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if route == nil {
+        delete(r.tasks, taskID)
+        return
+    }
+    r.tasks[taskID] = route
+}
+
+// watchStream forwards a chat or completion stream, recording its task on
+// the route while it runs and, for chats, pinning its conversation to the
+// route once the conversation ID appears.
+func (r *Router) watchStream(ctx context.Context, in <-chan ChunkChatCompletionResponse, route *routeState, chat bool) <-chan ChunkChatCompletionResponse {
+    out := make(chan ChunkChatCompletionResponse)
+    go func() {
+        defer close(out)
+        remembered := !chat
+        taskID := ""
+        defer func() {
+            if taskID != "" {
+                r.setTask(taskID, nil)
+            }
+        }()
+        for chunk := range in {
+            if taskID == "" && chunk.TaskID != "" {
+                taskID = chunk.TaskID
+                r.setTask(taskID, route)
+            }
+            if !remembered && chunk.ConversationID != "" {
+                r.remember(chunk.ConversationID, route)
+                remembered = true
+            }
+            select {
+            case out <- chunk:
+            case <-ctx.Done():
+                return
+            }
+        }
+    }()
+    return out
+}
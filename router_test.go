@@ -0,0 +1,201 @@
+package dify_test
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "testing"
+    "time"
+
+    dify "github.com/barlowliu/dify-go"
+    "github.com/barlowliu/dify-go/difytest"
+)
+
+// newRouter returns a Router over a primary and a backup server.
+func newRouter(t *testing.T) (router *dify.Router, primary, backup *difytest.Server) {
+    primary, backup = difytest.NewServer(), difytest.NewServer()
+    t.Cleanup(primary.Close)
+    t.Cleanup(backup.Close)
+    router = dify.NewRouter(
+        dify.Route{Name: "backup", Client: backup.Client(), Priority: 1},
+        dify.Route{Name: "primary", Client: primary.Client()},
+    )
+    return router, primary, backup
+}
+
+// echoConversation answers chat calls in the conversation they continue,
+// or in a new one named after the query.
+func echoConversation(req difytest.Request) difytest.Response {
+    body := req.JSON()
+    conversationID, _ := body["conversation_id"].(string)
+    if conversationID == "" {
+        conversationID = "conv-" + body["query"].(string)
+    }
+    return difytest.JSON(http.StatusOK, dify.ChatCompletionResponse{ConversationID: conversationID, Answer: "ok"})
+}
+
+// quotaExceeded rejects calls starting a conversation with a fallback code.
+func quotaExceeded(req difytest.Request) difytest.Response {
+    if id, _ := req.JSON()["conversation_id"].(string); id != "" {
+        return echoConversation(req)
+    }
+    return difytest.Error(http.StatusBadRequest, "provider_quota_exceeded", "Your quota has been exceeded.")
+}
+
+func routeChat(router *dify.Router, query, conversationID string) (*dify.ChatCompletionResponse, error) {
+    resp, _, err := router.SendChatMessage(context.Background(), dify.ChatMessageRequest{
+        Query:          query,
+        ConversationID: conversationID,
+        ResponseMode:   "blocking",
+        User:           "alice",
+    })
+    return resp, err
+}
+
+func TestRouterFallback(t *testing.T) {
+    tests := []struct {
+        name     string
+        status   int
+        code     string
+        fallback bool
+    }{
+        {"fallback code", http.StatusBadRequest, "provider_quota_exceeded", true},
+        {"server error", http.StatusInternalServerError, "internal_server_error", true},
+        {"other code", http.StatusBadRequest, "invalid_param", false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            router, primary, backup := newRouter(t)
+            primary.On(difytest.ChatMessages, difytest.Error(tt.status, tt.code, "failed"))
+
+            _, err := routeChat(router, "hi", "")
+            backupCalls := len(backup.Requests(difytest.ChatMessages))
+            if tt.fallback && (err != nil || backupCalls != 1) {
+                t.Errorf("err = %v with %d backup calls, want a fallback", err, backupCalls)
+            }
+            var apiErr *dify.APIError
+            if !tt.fallback && (!errors.As(err, &apiErr) || apiErr.Code != tt.code || backupCalls != 0) {
+                t.Errorf("err = %v with %d backup calls, want the primary's error", err, backupCalls)
+            }
+            primary.ExpectScriptsUsed(t)
+        })
+    }
+}
+
+func TestRouterSkipsUnhealthyRoute(t *testing.T) {
+    router, primary, backup := newRouter(t)
+    router.UnhealthyAfter = 2
+    router.RetryAfter = 50 * time.Millisecond
+    primary.Handle(difytest.ChatMessages, func(difytest.Request) difytest.Response {
+        return difytest.Error(http.StatusInternalServerError, "internal_server_error", "down")
+    })
+
+    for range 3 {
+        if _, err := routeChat(router, "hi", ""); err != nil {
+            t.Fatalf("SendChatMessage: %v", err)
+        }
+    }
+    primary.ExpectRequests(t, difytest.ChatMessages, 2)
+    backup.ExpectRequests(t, difytest.ChatMessages, 3)
+    if health := router.Health(); health[0].Name != "primary" || health[0].Healthy || health[0].Failures != 2 {
+        t.Errorf("Health() = %+v, want the primary unhealthy", health)
+    }
+
+    // After RetryAfter the primary is tried again and recovers.
+    time.Sleep(60 * time.Millisecond)
+    primary.Handle(difytest.ChatMessages, nil)
+    if _, err := routeChat(router, "hi", ""); err != nil {
+        t.Fatalf("SendChatMessage: %v", err)
+    }
+    primary.ExpectRequests(t, difytest.ChatMessages, 3)
+    if health := router.Health(); !health[0].Healthy || health[0].Failures != 0 {
+        t.Errorf("Health() = %+v, want the primary healthy", health)
+    }
+}
+
+func TestRouterPinsConversations(t *testing.T) {
+    router, primary, backup := newRouter(t)
+    router.MaxConversations = 2
+    primary.Handle(difytest.ChatMessages, quotaExceeded)
+    backup.Handle(difytest.ChatMessages, echoConversation)
+
+    for _, query := range []string{"a", "b"} {
+        if _, err := routeChat(router, query, ""); err != nil {
+            t.Fatalf("SendChatMessage(%q): %v", query, err)
+        }
+    }
+    // Continuing conv-a keeps it on the backup and makes conv-b the least
+    // recently used, so conv-c evicts it.
+    if _, err := routeChat(router, "again", "conv-a"); err != nil {
+        t.Fatalf("continuing conv-a: %v", err)
+    }
+    if _, err := routeChat(router, "c", ""); err != nil {
+        t.Fatalf("SendChatMessage(c): %v", err)
+    }
+    primary.Reset()
+    backup.Reset()
+    primary.Handle(difytest.ChatMessages, echoConversation)
+    backup.Handle(difytest.ChatMessages, echoConversation)
+
+    // The forgotten conv-b goes to the primary. It comes last, since
+    // pinning it there evicts conv-a.
+    for _, tt := range []struct {
+        conversationID string
+        want           *difytest.Server
+    }{
+        {"conv-a", backup},
+        {"conv-c", backup},
+        {"conv-b", primary},
+    } {
+        if _, err := routeChat(router, "next", tt.conversationID); err != nil {
+            t.Fatalf("continuing %s: %v", tt.conversationID, err)
+        }
+        if got := tt.want.ExpectLast(t, difytest.ChatMessages).JSON()["conversation_id"]; got != tt.conversationID {
+            t.Errorf("%s continued on the wrong route, which last saw %v", tt.conversationID, got)
+        }
+    }
+
+    // A pinned conversation does not fall back.
+    backup.On(difytest.ChatMessages, difytest.Error(http.StatusBadRequest, "provider_quota_exceeded", "Your quota has been exceeded."))
+    if _, err := routeChat(router, "next", "conv-c"); err == nil {
+        t.Error("a failing pinned conversation fell back")
+    }
+    primary.ExpectRequests(t, difytest.ChatMessages, 1)
+}
+
+func TestRouterStopTask(t *testing.T) {
+    router, primary, backup := newRouter(t)
+    primary.Handle(difytest.ChatMessages, quotaExceeded)
+    backup.On(difytest.ChatMessages, difytest.Stream(
+        difytest.Message("Hello"),
+        difytest.Message(" world").After(10*time.Second),
+    ))
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    _, events, err := router.SendChatMessage(ctx, dify.ChatMessageRequest{Query: "hi", ResponseMode: "streaming", User: "alice"})
+    if err != nil {
+        t.Fatalf("SendChatMessage: %v", err)
+    }
+    first := <-events
+    if _, err := router.StopTask(ctx, first.TaskID, "alice"); err != nil {
+        t.Fatalf("StopTask: %v", err)
+    }
+    for range events {
+    }
+    primary.ExpectRequests(t, difytest.StopChatMessage, 0)
+    if stop := backup.ExpectLast(t, difytest.StopChatMessage); stop.PathValues["task_id"] != first.TaskID {
+        t.Errorf("stopped %q, want %q", stop.PathValues["task_id"], first.TaskID)
+    }
+
+    if _, err := router.StopTask(ctx, first.TaskID, "alice"); !errors.Is(err, dify.ErrUnknownTask) {
+        t.Errorf("StopTask after the stream ended = %v, want ErrUnknownTask", err)
+    }
+
+    // The stream pinned its conversation to the backup.
+    backup.Handle(difytest.ChatMessages, echoConversation)
+    if _, err := routeChat(router, "next", first.ConversationID); err != nil {
+        t.Fatalf("continuing the streamed conversation: %v", err)
+    }
+    backup.ExpectRequests(t, difytest.ChatMessages, 2)
+}
@@ -1,57 +1,28 @@
-package dify-go
+package dify
 
 import (
-    "bytes"
     "context"
-    "encoding/json"
     "fmt"
-
-    "github.com/hashicorp/go-retryablehttp"
 )
 
 // StopTask stops an ongoing stream task by its task_id.
 func (c *Client) StopTask(ctx context.Context, taskID, user string) (*StopResponse, error) {
-    endpoint := fmt.Sprintf("/chat-messages/%s/stop", taskID)
-    url := c.buildURL(endpoint)
+    ep := endpoint{name: "StopTask", method: "POST", path: fmt.Sprintf("/chat-messages/%s/stop", taskID)}
 
     // Prepare request body
     body := map[string]string{
         "user": user,
     }
-    bodyBytes, err := json.Marshal(body)
-    if err != nil {
-        return nil, err
-    }
-
-    // Create new request
-    req, err := retryablehttp.NewRequest("POST", url, bytes.NewReader(bodyBytes))
-    if err != nil {
-        return nil, err
-    }
-
-    // Add headers
-    c.addHeaders(req)
-
-    // Execute request
-    resp, err := c.HTTPClient.Do(req)
-    if err != nil {
-        return nil, err
-    }
-    defer resp.Body.Close()
+    return do[map[string]string, StopResponse](ctx, c, ep, body)
+}
 
-    if resp.StatusCode != 200 {
-        var apiErr APIError
-        if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil {
-            return nil, fmt.Errorf("status code: %d", resp.StatusCode)
-        }
-        return nil, &apiErr
-    }
+// StopCompletionMessage stops an ongoing completion stream by its task_id.
+func (c *Client) StopCompletionMessage(ctx context.Context, taskID, user string) (*StopResponse, error) {
+    ep := endpoint{name: "StopCompletionMessage", method: "POST", path: fmt.Sprintf("/completion-messages/%s/stop", taskID)}
 
-    var stopResp StopResponse
-    if err := json.NewDecoder(resp.Body).Decode(&stopResp); err != nil {
-        return nil, err
+    // Prepare request body
+    body := map[string]string{
+        "user": user,
     }
-
-    return &stopResp, nil
+    return do[map[string]string, StopResponse](ctx, c, ep, body)
 }
-
@@ -0,0 +1,83 @@
+package dify
+
+import (
+    "context"
+    "fmt"
+)
+
+// CreateKnowledgeTag creates a new knowledge tag.
+func (c *Client) CreateKnowledgeTag(ctx context.Context, name string) (*KnowledgeTag, error) {
+    ep := endpoint{name: "CreateKnowledgeTag", method: "POST", path: "/datasets/tags"}
+
+    // Prepare request body
+    body := map[string]string{
+        "name": name,
+    }
+    return do[map[string]string, KnowledgeTag](ctx, c, ep, body)
+}
+
+// ListKnowledgeTags lists all knowledge tags of the workspace.
+func (c *Client) ListKnowledgeTags(ctx context.Context) ([]KnowledgeTag, error) {
+    ep := endpoint{name: "ListKnowledgeTags", method: "GET", path: "/datasets/tags"}
+    tags, err := do[noBody, []KnowledgeTag](ctx, c, ep, noBody{})
+    if err != nil {
+        return nil, err
+    }
+    return *tags, nil
+}
+
+// RenameKnowledgeTag changes the name of a knowledge tag.
+func (c *Client) RenameKnowledgeTag(ctx context.Context, tagID, name string) (*KnowledgeTag, error) {
+    ep := endpoint{name: "RenameKnowledgeTag", method: "PATCH", path: "/datasets/tags"}
+
+    // Prepare request body
+    body := map[string]string{
+        "tag_id": tagID,
+        "name":   name,
+    }
+    return do[map[string]string, KnowledgeTag](ctx, c, ep, body)
+}
+
+// DeleteKnowledgeTag deletes a knowledge tag and all of its dataset bindings.
+func (c *Client) DeleteKnowledgeTag(ctx context.Context, tagID string) error {
+    ep := endpoint{name: "DeleteKnowledgeTag", method: "DELETE", path: "/datasets/tags"}
+
+    // Prepare request body
+    body := map[string]string{
+        "tag_id": tagID,
+    }
+    _, err := do[map[string]string, struct{}](ctx, c, ep, body)
+    return err
+}
+
+// BindDatasetTags binds one or more knowledge tags to a dataset.
+func (c *Client) BindDatasetTags(ctx context.Context, datasetID string, tagIDs []string) error {
+    ep := endpoint{name: "BindDatasetTags", method: "POST", path: "/datasets/tags/binding"}
+
+    // Prepare request body
+    body := map[string]interface{}{
+        "tag_ids":   tagIDs,
+        "target_id": datasetID,
+    }
+    _, err := do[map[string]interface{}, struct{}](ctx, c, ep, body)
+    return err
+}
+
+// UnbindDatasetTag removes a knowledge tag from a dataset.
+func (c *Client) UnbindDatasetTag(ctx context.Context, datasetID, tagID string) error {
+    ep := endpoint{name: "UnbindDatasetTag", method: "POST", path: "/datasets/tags/unbinding"}
+
+    // Prepare request body
+    body := map[string]string{
+        "tag_id":    tagID,
+        "target_id": datasetID,
+    }
+    _, err := do[map[string]string, struct{}](ctx, c, ep, body)
+    return err
+}
+
+// GetDatasetTags lists the knowledge tags bound to a dataset.
+func (c *Client) GetDatasetTags(ctx context.Context, datasetID string) (*DatasetTagsResponse, error) {
+    ep := endpoint{name: "GetDatasetTags", method: "GET", path: fmt.Sprintf("/datasets/%s/tags", datasetID)}
+    return do[noBody, DatasetTagsResponse](ctx, c, ep, noBody{})
+}
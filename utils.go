@@ -1,11 +1,5 @@
 package dify
 
-import (
-    "bytes"
-    "io"
-)
-
 // bytes.NewReader is used multiple times, but since it's a standard library function,
 // no additional utility functions are required here.
 // You can add helper functions here if needed in the future.
-
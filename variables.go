@@ -0,0 +1,41 @@
+package dify
+
+import (
+    "context"
+    "fmt"
+    "net/url"
+    "strconv"
+)
+
+// GetConversationVariables lists the variables of a chatflow conversation.
+// LastID is the ID of the last variable of the previous page and is empty
+// for the first page; a non-empty variableName filters by exact name.
+func (c *Client) GetConversationVariables(ctx context.Context, conversationID, user, lastID string, limit int, variableName string) (*ConversationVariablesResponse, error) {
+    query := url.Values{}
+    query.Set("user", user)
+    if lastID != "" {
+        query.Set("last_id", lastID)
+    }
+    if limit > 0 {
+        query.Set("limit", strconv.Itoa(limit))
+    }
+    if variableName != "" {
+        query.Set("variable_name", variableName)
+    }
+    ep := endpoint{name: "GetConversationVariables", method: "GET", path: fmt.Sprintf("/conversations/%s/variables", conversationID), query: query}
+    return do[noBody, ConversationVariablesResponse](ctx, c, ep, noBody{})
+}
+
+// UpdateConversationVariable sets the value of a conversation variable.
+// The value must match the variable's value_type. Older Dify versions do
+// not expose this endpoint and answer with a 404 error.
+func (c *Client) UpdateConversationVariable(ctx context.Context, conversationID, variableID, user string, value interface{}) (*ConversationVariable, error) {
+    ep := endpoint{name: "UpdateConversationVariable", method: "PUT", path: fmt.Sprintf("/conversations/%s/variables/%s", conversationID, variableID)}
+
+    // Prepare request body
+    body := map[string]interface{}{
+        "value": value,
+        "user":  user,
+    }
+    return do[map[string]interface{}, ConversationVariable](ctx, c, ep, body)
+}
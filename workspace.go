@@ -0,0 +1,33 @@
+package dify
+
+import (
+    "context"
+)
+
+// GetEmbeddingModels lists the text embedding models configured in the
+// current workspace, grouped by provider.
+func (c *Client) GetEmbeddingModels(ctx context.Context) (*EmbeddingModelsResponse, error) {
+    ep := endpoint{name: "GetEmbeddingModels", method: "GET", path: "/workspaces/current/models/model-types/text-embedding"}
+    return do[noBody, EmbeddingModelsResponse](ctx, c, ep, noBody{})
+}
+
+// Available returns the provider/model pairs whose provider and model are
+// both active and whose model is not deprecated, in the order Dify lists them.
+func (r *EmbeddingModelsResponse) Available() []EmbeddingModelChoice {
+    var choices []EmbeddingModelChoice
+    for _, provider := range r.Data {
+        if provider.Status != "active" {
+            continue
+        }
+        for _, model := range provider.Models {
+            if model.Status != "active" || model.Deprecated {
+                continue
+            }
+            choices = append(choices, EmbeddingModelChoice{
+                Provider: provider.Provider,
+                Model:    model.Model,
+            })
+        }
+    }
+    return choices
+}